@@ -3,7 +3,18 @@ package clog
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/wk8/go-ordered-map/v2"
 )
@@ -45,65 +56,530 @@ const (
 )
 
 type canonical struct {
+	mu     sync.Mutex
 	values *orderedmap.OrderedMap[string, any] //nolint:typecheck
+	opts   *options
+
+	// cardinalityWarned holds the parents already reported to the cardinality warning function.
+	cardinalityWarned map[string]bool
+	// checkpoints holds the times recorded by Checkpoint.
+	checkpoints map[string]time.Time
+	// reservoirs holds the samples kept by ObserveDuration, keyed by dot-separated path.
+	reservoirs map[string]*reservoir
+	// sealed is set by Seal to reject further writes.
+	sealed bool
+	// namespaces holds the canonicals created by InitNamespace, in creation order.
+	namespaces []namespace
 }
 
-func newCanonical() *canonical {
+// disabled is a sentinel canonical installed by Disabled.  It never records values.
+var disabled = &canonical{}
+
+func newCanonical(opts *options) *canonical {
+	if opts == nil {
+		opts = newOptions(nil)
+	}
 	return &canonical{
 		values: orderedmap.New[string, any](), //nolint:typecheck
+		opts:   opts,
 	}
 }
 
 // Init initializes the canonical logging context.  This must be called before any other canonical logging functions
 // are called.  This is typically called at the beginning of a request handler or the beginning of a background task.
+// Providers registered with RegisterGlobalProvider are run against each newly initialized context.
 func Init(ctx context.Context) context.Context {
+	return initWithOptions(ctx, nil)
+}
+
+// InitWithOptions initializes the canonical logging context like Init, applying opts to the new context.  If ctx is
+// already initialized, the existing context and its options are kept.
+func InitWithOptions(ctx context.Context, opts ...Option) context.Context {
+	return initWithOptions(ctx, newOptions(opts))
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   []func(ctx context.Context)
+)
+
+// RegisterGlobalProvider registers provide to seed every newly initialized canonical logging context with ambient
+// fields, such as the build SHA or region.  Providers run in registration order each time Init, InitWithOptions or the
+// CanonicalLogger middleware creates a context, and are not run when an already initialized context is passed in.
+// Providers are typically registered during program initialization and cannot be removed.
+func RegisterGlobalProvider(provide func(ctx context.Context)) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers = append(providers, provide)
+}
+
+func initWithOptions(ctx context.Context, opts *options) context.Context {
 	v := ctx.Value(contextKey)
 	if v == nil {
-		ctx = context.WithValue(ctx, contextKey, newCanonical())
+		ctx = context.WithValue(ctx, contextKey, newCanonical(opts))
+		providersMu.RLock()
+		registered := providers
+		providersMu.RUnlock()
+		for _, provide := range registered {
+			provide(ctx)
+		}
+		return ctx
+	}
+	if v == disabled {
+		return ctx
+	}
+
+	warn := func() {}
+	if c, ok := v.(*canonical); ok && c.opts != nil && c.opts.initWarn != nil {
+		warn = c.opts.initWarn
 	}
+	if opts != nil && opts.initWarn != nil {
+		warn = opts.initWarn
+	}
+	warn()
 	return ctx
 }
 
-// MarshalJSON returns the canonical logging context as a JSON string.
+// Disabled returns a context with canonical logging disabled.  All setters become no-ops and MarshalJSON returns an
+// empty string.  Calling Init on a disabled context leaves it disabled.  This is useful in benchmarks or when logging
+// is turned off.
+func Disabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey, disabled)
+}
+
+// fromContext returns the canonical stored in ctx.  It returns false if ctx was not initialized or is disabled.
+func fromContext(ctx context.Context) (*canonical, bool) {
+	c, ok := ctx.Value(contextKey).(*canonical)
+	if !ok || c == disabled {
+		return nil, false
+	}
+	return c, true
+}
+
+// MarshalJSON returns the canonical logging context as a JSON string.  It returns an empty string if the context is
+// not initialized or cannot be marshaled.
 func MarshalJSON(ctx context.Context) string {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
-		return c.string()
+	b, err := MarshalJSONBytes(ctx)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// MarshalJSONBytes returns the canonical logging context as JSON.  It avoids the string copy made by MarshalJSON when
+// the caller needs a []byte.  It returns nil if the context is not initialized.
+func MarshalJSONBytes(ctx context.Context) ([]byte, error) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.marshal()
+	}
+	return nil, nil
+}
+
+// MarshalWith returns the canonical logging context encoded by marshal, with redaction and aliases applied as they are
+// for MarshalJSON.  Nested keys are passed to marshal as nested map[string]any values.  It lets encodings such as CBOR
+// live in their own packages so that their dependencies are only linked by programs that use them.  It returns nil if
+// the context is not initialized.
+func MarshalWith(ctx context.Context, marshal func(v any) ([]byte, error)) ([]byte, error) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		plain := toPlain(c.render())
+		c.mu.Unlock()
+		return marshal(plain)
+	}
+	return nil, nil
+}
+
+// MarshalSubtreeJSON returns the nested object at key as a JSON string, with redaction and aliases applied as they
+// are for the whole event.  It returns an empty string if the context is not initialized or key is not an object.
+func MarshalSubtreeJSON(ctx context.Context, key string) string {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		val, _ := c.detached(c.render()).get(c.normalizeKey(key))
+		subtree, ok := val.(*orderedmap.OrderedMap[string, any]) //nolint:typecheck
+		if !ok {
+			return ""
+		}
+		b, err := c.encode(subtree)
+		if err != nil {
+			return ""
+		}
+		return string(b)
 	}
 	return ""
 }
 
+// Seal makes the canonical logging context read-only.  Later writes are ignored and counted in
+// clog.write_after_seal_count, which helps find code that records values after the event was emitted; with
+// WithStrictSeal they panic instead.  Namespaces created by InitNamespace are sealed too.  The CanonicalLogger
+// middleware seals the context after emitting the event.
+func Seal(ctx context.Context) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.seal()
+	}
+}
+
+// seal marks c and its namespaces as sealed.  c must be locked.
+func (c *canonical) seal() {
+	c.sealed = true
+	for _, ns := range c.namespaces {
+		ns.c.mu.Lock()
+		ns.c.seal()
+		ns.c.mu.Unlock()
+	}
+}
+
+// Prune removes nested objects that are empty, or only hold empty objects, from the canonical logging context.  Empty
+// objects are always left out when the event is marshaled; Prune also removes them from the context itself, for example
+// before inspecting it with Walk or Snapshot.
+func Prune(ctx context.Context) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		prune(c.values)
+	}
+}
+
+// SwapAndMarshal atomically replaces the canonical logging context with an empty one and returns the previous
+// contents as a JSON string.  Values set concurrently end up either in the returned event or in the new one, never
+// partially in both.  It returns an empty string if the context is not initialized or cannot be marshaled.
+func SwapAndMarshal(ctx context.Context) string {
+	b, err := swapAndMarshal(ctx)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// swapAndMarshal is SwapAndMarshal returning the marshaling error.  It returns nil if ctx is not initialized.
+func swapAndMarshal(ctx context.Context) ([]byte, error) {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	old := c.swap()
+	c.mu.Unlock()
+
+	return old.marshal()
+}
+
 // SetString sets a string value in the canonical logging context.  If the string exists, it will be overwritten.
 func SetString(ctx context.Context, key, value string) {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		c.setString(key, value)
 	}
 }
 
+// SetStringf formats according to a format specifier and sets the resulting string in the canonical logging context.
+// If the string exists, it will be overwritten.
+func SetStringf(ctx context.Context, key, format string, args ...any) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setString(key, fmt.Sprintf(format, args...))
+	}
+}
+
 // SetInt sets an int value in the canonical logging context.  If the int exists, it will be overwritten.
 func SetInt(ctx context.Context, key string, value int) {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		c.setInt(key, value)
 	}
 }
 
 // SetFloat64 sets a float64 value in the canonical logging context.  If the float64 exists, it will be overwritten.
 func SetFloat64(ctx context.Context, key string, value float64) {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		c.setFloat64(key, value)
 	}
 }
 
-// AddInt adds an int value to the canonical logging context.  If the int does not exist, it will be created.
-func AddInt(ctx context.Context, key string, value int) {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
-		c.addInt(key, value)
+// SetFloat32 sets a float32 value in the canonical logging context.  The value is stored as the float64 with the
+// shortest decimal form that rounds to the same float32, so 0.1 is logged as 0.1 rather than 0.10000000149011612.  No
+// more precision than float32 provides should be expected.  If the value exists, it will be overwritten.
+func SetFloat32(ctx context.Context, key string, value float32) {
+	SetFloat64(ctx, key, widenFloat32(value))
+}
+
+// widenFloat32 converts f to the float64 with the same shortest decimal representation.
+func widenFloat32(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
+
+// SetDuration sets a duration in the canonical logging context as a number of milliseconds, or as a string such as
+// "1.2s" when WithDurationStringFormat is used.  If the value exists, it will be overwritten.
+func SetDuration(ctx context.Context, key string, value time.Duration) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.opts.durationString {
+			c.setString(key, value.String())
+			return
+		}
+		c.setInt(key, int(value.Milliseconds()))
+	}
+}
+
+// SetDurationNanos sets a duration in the canonical logging context as an int64 number of nanoseconds, the unit used by
+// OpenTelemetry semantic conventions.  The full precision is kept in the marshaled JSON.  If the value exists, it will
+// be overwritten.
+func SetDurationNanos(ctx context.Context, key string, value time.Duration) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setValue(key, value.Nanoseconds())
 	}
 }
 
+// Number is the set of numeric types accepted by Add.
+type Number interface {
+	int | int64 | float64
+}
+
+// Add adds value to the number at key in the canonical logging context.  If the number does not exist, it will be
+// created.  Integers are summed as ints, with overflowing sums clamped to math.MaxInt or math.MinInt and clog.overflow
+// set to true.  Integers added to an int64 value, such as one written by SetDurationNanos, are summed as int64s and clamp
+// to math.MaxInt64 or math.MinInt64.  Floats are summed as float64s and follow the WithNonFiniteFloats policy.  Adding
+// an integer to a float or a float to an integer is ignored.
+func Add[T Number](ctx context.Context, key string, value T) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		parts := c.normalizeKey(key)
+		var v any
+		var applied bool
+		switch n := any(value).(type) {
+		case int:
+			v = n
+			applied = c.add(parts, n)
+		case int64:
+			i, overflow := clampInt64(n)
+			if overflow {
+				c.setValue("clog.overflow", true)
+			}
+			v = i
+			applied = c.add(parts, i)
+		case float64:
+			v = n
+			applied = c.addFloat(parts, n)
+		}
+		if applied && c.opts.rollupTotals {
+			c.rollup(parts, v)
+		}
+	}
+}
+
+// AddInt adds an int value to the canonical logging context.  If the int does not exist, it will be created.  Sums
+// that would overflow are clamped to math.MaxInt or math.MinInt and clog.overflow is set to true.
+func AddInt(ctx context.Context, key string, value int) {
+	Add(ctx, key, value)
+}
+
 // AddFloat64 adds a float64 value to the canonical logging context.  If the float64 does not exist, it will be created.
 func AddFloat64(ctx context.Context, key string, value float64) {
-	if c, ok := ctx.Value(contextKey).(*canonical); ok {
-		c.addFloat64(key, value)
+	Add(ctx, key, value)
+}
+
+// AddFloat32 adds a float32 value to the canonical logging context, converted as by SetFloat32.  The sum is kept as a
+// float64.  If the value does not exist, it will be created.
+func AddFloat32(ctx context.Context, key string, value float32) {
+	AddFloat64(ctx, key, widenFloat32(value))
+}
+
+// SetMaxInt sets an int value in the canonical logging context only if it is greater than the existing value.  If the
+// int does not exist, it will be created.  This tracks values like the slowest query in a request.
+func SetMaxInt(ctx context.Context, key string, value int) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), value, func(old any) bool {
+			o, ok := old.(int)
+			return !ok || value > o
+		})
+	}
+}
+
+// SetMinInt sets an int value in the canonical logging context only if it is less than the existing value.  If the
+// int does not exist, it will be created.
+func SetMinInt(ctx context.Context, key string, value int) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), value, func(old any) bool {
+			o, ok := old.(int)
+			return !ok || value < o
+		})
+	}
+}
+
+// SetMaxFloat64 sets a float64 value in the canonical logging context only if it is greater than the existing value.
+// If the float64 does not exist, it will be created.
+func SetMaxFloat64(ctx context.Context, key string, value float64) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), value, func(old any) bool {
+			o, ok := old.(float64)
+			return !ok || value > o
+		})
+	}
+}
+
+// SetMinFloat64 sets a float64 value in the canonical logging context only if it is less than the existing value.  If
+// the float64 does not exist, it will be created.
+func SetMinFloat64(ctx context.Context, key string, value float64) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), value, func(old any) bool {
+			o, ok := old.(float64)
+			return !ok || value < o
+		})
+	}
+}
+
+// GetOrSet returns the value stored at key.  If the key does not exist, compute is called and its result is stored
+// and returned.  The context is locked while compute runs, so compute runs at most once per key even when called
+// concurrently, and it must not call other clog functions on the same context.  If the context is not initialized,
+// the result of compute is returned without being stored.
+func GetOrSet(ctx context.Context, key string, compute func() any) any {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return compute()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parts := c.normalizeKey(key)
+	if value, ok := c.get(parts); ok {
+		return value
+	}
+	value := compute()
+	c.set(parts, value)
+	return value
+}
+
+// AddIntPath adds an int value at the path formed by parts.  Unlike AddInt, the parts are not split on dots, so
+// segments may contain arbitrary characters such as hostnames or table names.  If the int does not exist, it will be
+// created.
+func AddIntPath(ctx context.Context, value int, parts ...string) {
+	if len(parts) == 0 {
+		return
+	}
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		parts := c.normalizePath(parts)
+		if c.add(parts, value) && c.opts.rollupTotals {
+			c.rollup(parts, value)
+		}
+	}
+}
+
+// AddMap folds a nested map into the canonical logging context under prefix.  Numeric leaves are added to the existing
+// values, creating them as needed, while all other leaves overwrite the existing values.  Keys are processed in sorted
+// order.  An empty prefix folds the map into the root of the event.
+func AddMap(ctx context.Context, prefix string, m map[string]any) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var parts []string
+		if prefix != "" {
+			parts = c.normalizeKey(prefix)
+		}
+		c.addMap(parts, m)
+	}
+}
+
+// SetSubEvent parses eventJSON, typically an event returned by a downstream service, and stores it as a nested object
+// under key.  If eventJSON is not a valid JSON object, nothing is stored under key and the error is recorded in
+// clog.error.
+func SetSubEvent(ctx context.Context, key string, eventJSON string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		sub, err := decodeObject([]byte(eventJSON))
+		if err != nil {
+			c.recordError(fmt.Errorf("SetSubEvent %s: %w", key, err))
+			return
+		}
+		c.setValue(key, sub)
+	}
+}
+
+// WithTempFields sets fields in the canonical logging context and returns a function that removes them again.  Fields
+// that already existed are restored to their prior values instead of being removed.  This lets library code add
+// context for a sub-operation without leaking it into the rest of the event.
+func WithTempFields(ctx context.Context, fields map[string]any) (restore func()) {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return func() {}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type prior struct {
+		parts  []string
+		value  any
+		exists bool
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	priors := make([]prior, 0, len(keys))
+	for _, key := range keys {
+		parts := c.normalizeKey(key)
+		value, exists := c.get(parts)
+		priors = append(priors, prior{parts: parts, value: value, exists: exists})
+		c.set(parts, fields[key])
+	}
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i := len(priors) - 1; i >= 0; i-- {
+			p := priors[i]
+			if p.exists {
+				c.put(p.parts, p.value)
+			} else {
+				c.delete(p.parts)
+			}
+		}
+	}
+}
+
+// setValue sets a value of any type in the canonical logging context.
+func setValue(ctx context.Context, key string, value any) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setValue(key, value)
+	}
+}
+
+// setValuePath sets a value of any type at the path formed by parts without splitting them on dots.
+func setValuePath(ctx context.Context, value any, parts ...string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.set(c.normalizePath(parts), value)
 	}
 }
 
@@ -111,81 +587,696 @@ func (c *canonical) normalizeKey(key string) []string {
 	return strings.Split(strings.ToLower(key), ".")
 }
 
+// normalizePath lowercases pre-split path segments.
+func (c *canonical) normalizePath(parts []string) []string {
+	normalized := make([]string, len(parts))
+	for i, p := range parts {
+		normalized[i] = strings.ToLower(p)
+	}
+	return normalized
+}
+
 func (c *canonical) setString(key string, value string) {
-	c.set(c.normalizeKey(key), c.values, value)
+	c.set(c.normalizeKey(key), value)
 }
 
 func (c *canonical) setInt(key string, value int) {
-	c.set(c.normalizeKey(key), c.values, value)
+	c.set(c.normalizeKey(key), value)
 }
 
 func (c *canonical) setFloat64(key string, value float64) {
-	c.set(c.normalizeKey(key), c.values, value)
+	c.set(c.normalizeKey(key), value)
+}
+
+func (c *canonical) setValue(key string, value any) {
+	c.set(c.normalizeKey(key), value)
+}
+
+// recordError records an error about the use of the canonical logging context in clog.error.
+func (c *canonical) recordError(err error) {
+	c.setValue("clog.error", err.Error())
 }
 
-func (c *canonical) set(parts []string, state *orderedmap.OrderedMap[string, any], value any) { //nolint:typecheck
-	if len(parts) == 1 {
-		state.Set(parts[0], value)
+// container walks parts and returns the map holding the leaf along with the leaf key.  Missing intermediate maps are
+// created and intermediate values that are not maps are replaced.  Paths deeper than the configured max depth are
+// truncated by joining the remaining parts into the leaf key.
+func (c *canonical) container(parts []string) (*orderedmap.OrderedMap[string, any], string) { //nolint:typecheck
+	parts = c.truncate(parts)
+	state := c.values
+	for i, part := range parts[:len(parts)-1] {
+		val, _ := state.Get(part)
+		next, ok := val.(*orderedmap.OrderedMap[string, any])
+		if !ok {
+			c.checkCardinality(state, part, parts[:i])
+			next = orderedmap.New[string, any]() //nolint:typecheck
+			state.Set(part, next)
+		}
+		state = next
+	}
+	leaf := parts[len(parts)-1]
+	c.checkCardinality(state, leaf, parts[:len(parts)-1])
+	return state, leaf
+}
+
+// checkCardinality calls the cardinality warning function when adding key to state would give the parent at path
+// more distinct keys than the configured limit.  The function is called at most once per parent.
+func (c *canonical) checkCardinality(state *orderedmap.OrderedMap[string, any], key string, path []string) { //nolint:typecheck
+	if c.opts.cardinalityWarn == nil || state.Len() < c.opts.cardinalityLimit {
 		return
 	}
+	if _, ok := state.Get(key); ok {
+		return
+	}
+	parent := strings.Join(path, ".")
+	if c.cardinalityWarned == nil {
+		c.cardinalityWarned = map[string]bool{}
+	}
+	if !c.cardinalityWarned[parent] {
+		c.cardinalityWarned[parent] = true
+		c.opts.cardinalityWarn(parent)
+	}
+}
 
-	val, ok := state.Get(parts[0])
-	if !ok {
-		val = orderedmap.New[string, any]() //nolint:typecheck
-		state.Set(parts[0], val)
+// lookup walks parts and returns the map holding the leaf along with the leaf key without modifying the tree.  It
+// returns nil if an intermediate map does not exist.
+func (c *canonical) lookup(parts []string) (*orderedmap.OrderedMap[string, any], string) { //nolint:typecheck
+	parts = c.truncate(parts)
+	state := c.values
+	for _, part := range parts[:len(parts)-1] {
+		val, _ := state.Get(part)
+		next, ok := val.(*orderedmap.OrderedMap[string, any])
+		if !ok {
+			return nil, ""
+		}
+		state = next
 	}
-	c.set(parts[1:], val.(*orderedmap.OrderedMap[string, any]), value)
+	return state, parts[len(parts)-1]
 }
 
-func (c *canonical) addInt(key string, value int) {
-	c.add(c.normalizeKey(key), c.values, value)
+// truncate joins the parts beyond the configured max depth into the deepest allowed key.
+func (c *canonical) truncate(parts []string) []string {
+	if maxDepth := c.opts.maxDepth; maxDepth > 0 && len(parts) > maxDepth {
+		parts = append(parts[:maxDepth-1:maxDepth-1], strings.Join(parts[maxDepth-1:], "."))
+	}
+	return parts
 }
 
-func (c *canonical) add(parts []string, state *orderedmap.OrderedMap[string, any], value int) { //nolint:typecheck
-	if len(parts) == 1 {
-		val, ok := state.Get(parts[0])
-		if !ok {
-			state.Set(parts[0], value)
+func (c *canonical) get(parts []string) (any, bool) {
+	state, leaf := c.lookup(parts)
+	if state == nil {
+		return nil, false
+	}
+	return state.Get(leaf)
+}
+
+func (c *canonical) delete(parts []string) {
+	if state, leaf := c.lookup(parts); state != nil {
+		state.Delete(leaf)
+	}
+}
+
+// set stores value at parts according to the configured write policy, after running the transforms registered for it.
+func (c *canonical) set(parts []string, value any) {
+	if c.opts.writePolicy == FirstWins {
+		if _, ok := c.get(parts); ok {
+			return
 		}
-		if vv, ok := val.(int); ok {
-			state.Set(parts[0], vv+value)
+	}
+	if fns := c.opts.transforms[strings.Join(parts, ".")]; fns != nil {
+		for _, fn := range fns {
+			value = fn(value)
 		}
+	}
+	c.put(parts, value)
+}
+
+// put stores value at parts regardless of the write policy.
+func (c *canonical) put(parts []string, value any) {
+	if !c.writable() {
 		return
 	}
+	c.trace("SET", parts, value)
+	if f, ok := value.(float64); ok && !isFinite(f) {
+		if value, ok = c.nonFinite(parts, f); !ok {
+			return
+		}
+	}
+	state, leaf := c.container(parts)
+	state.Set(leaf, c.prepare(value))
+	if c.opts.sourceAnnotations {
+		state.Set(leaf+".__source", callerSource())
+	}
+}
+
+// writable reports whether values may be written.  Once the canonical is sealed, rejected writes are counted in
+// clog.write_after_seal_count, or cause a panic under WithStrictSeal.
+func (c *canonical) writable() bool {
+	if !c.sealed {
+		return true
+	}
+	if c.opts.strictSeal {
+		panic("clog: write after Seal")
+	}
+	c.countWriteAfterSeal()
+	return false
+}
 
-	val, ok := state.Get(parts[0])
+// countWriteAfterSeal increments clog.write_after_seal_count for a write rejected by Seal.
+func (c *canonical) countWriteAfterSeal() {
+	state, leaf := c.container([]string{"clog", "write_after_seal_count"})
+	n, _ := state.Get(leaf)
+	count, _ := n.(int)
+	state.Set(leaf, count+1)
+}
+
+// trace writes a line describing a write to the configured trace writer, if any.
+func (c *canonical) trace(op string, parts []string, value any) {
+	if c.opts.traceWriter == nil {
+		return
+	}
+	var v string
+	switch value.(type) {
+	case *orderedmap.OrderedMap[string, any], []any, []string: //nolint:typecheck
+		b, _ := json.Marshal(value)
+		v = string(b)
+	default:
+		v = fmt.Sprint(value)
+	}
+	_, _ = fmt.Fprintf(c.opts.traceWriter, "%s %s=%s\n", op, strings.Join(parts, "."), v)
+}
+
+// callerSource returns the file:line of the first caller outside of this package.  Test files are treated as callers
+// so that annotations can be tested.
+func callerSource() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/jwilder/clog.") || strings.HasSuffix(frame.File, "_test.go") {
+			return filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// setIf sets value at parts if the key does not exist or replace returns true for the existing value.
+func (c *canonical) setIf(parts []string, value any, replace func(old any) bool) {
+	if old, ok := c.get(parts); ok && !replace(old) {
+		return
+	}
+	c.put(parts, value)
+}
+
+// prepare applies the configured value sanitization and limits to a value before it is stored.  Strings nested in
+// arrays and objects are prepared as well, and the containers are copied rather than modified.
+func (c *canonical) prepare(value any) any {
+	if !c.opts.sanitizeValues && c.opts.maxValueBytes <= 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		return c.prepareString(v)
+	case []string:
+		out := make([]string, len(v))
+		for i, s := range v {
+			out[i] = c.prepareString(s)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = c.prepare(item)
+		}
+		return out
+	case *orderedmap.OrderedMap[string, any]: //nolint:typecheck
+		out := orderedmap.New[string, any](orderedmap.WithCapacity[string, any](v.Len())) //nolint:typecheck
+		for pair := v.Oldest(); pair != nil; pair = pair.Next() {
+			out.Set(pair.Key, c.prepare(pair.Value))
+		}
+		return out
+	}
+	return value
+}
+
+// prepareString applies WithSanitizeValues and WithMaxValueBytes to s.
+func (c *canonical) prepareString(s string) string {
+	if c.opts.sanitizeValues {
+		s = sanitize(s)
+	}
+	if c.opts.maxValueBytes > 0 && len(s) > c.opts.maxValueBytes {
+		n := c.opts.maxValueBytes
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n]
+	}
+	return s
+}
+
+// sanitize replaces newlines and tabs in s with spaces and removes all other control characters.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// append appends value to the array at parts.  A value that is not an array is replaced.
+func (c *canonical) append(parts []string, value any) {
+	if !c.writable() {
+		return
+	}
+	c.trace("APPEND", parts, value)
+	state, leaf := c.container(parts)
+	val, _ := state.Get(leaf)
+	arr, _ := val.([]any)
+	state.Set(leaf, append(arr, c.prepare(value)))
+}
+
+func (c *canonical) addMap(prefix []string, m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		parts := append(prefix[:len(prefix):len(prefix)], strings.ToLower(key))
+		switch v := m[key].(type) {
+		case map[string]any:
+			c.addMap(parts, v)
+		case int:
+			c.add(parts, v)
+		case int64:
+			c.add(parts, int(v))
+		case int32:
+			c.add(parts, int(v))
+		case float64:
+			c.addFloat(parts, v)
+		case float32:
+			c.addFloat(parts, float64(v))
+		default:
+			c.set(parts, v)
+		}
+	}
+}
+
+func (c *canonical) addInt(key string, value int) {
+	c.add(c.normalizeKey(key), value)
+}
+
+// add adds value to the int at parts, creating it if needed.  It reports whether the value was added; adds to a sealed
+// context or to a key holding another type are ignored.
+func (c *canonical) add(parts []string, value int) bool {
+	if !c.writable() {
+		return false
+	}
+	c.trace("ADD", parts, value)
+	state, leaf := c.container(parts)
+	val, ok := state.Get(leaf)
 	if !ok {
-		val = orderedmap.New[string, any]()
-		state.Set(parts[0], val)
+		state.Set(leaf, value)
+		return true
+	}
+	var overflow bool
+	switch vv := val.(type) {
+	case int:
+		var sum int
+		sum, overflow = addSaturating(vv, value)
+		state.Set(leaf, sum)
+	case int64:
+		var sum int64
+		sum, overflow = addSaturating64(vv, int64(value))
+		state.Set(leaf, sum)
+	default:
+		return false
+	}
+	if overflow {
+		c.setValue("clog.overflow", true)
+	}
+	return true
+}
+
+// addSaturating returns a+b clamped to the range of int, and whether clamping was needed.
+func addSaturating(a, b int) (int, bool) {
+	sum := a + b
+	switch {
+	case b > 0 && sum < a:
+		return math.MaxInt, true
+	case b < 0 && sum > a:
+		return math.MinInt, true
 	}
-	c.add(parts[1:], val.(*orderedmap.OrderedMap[string, any]), value)
+	return sum, false
 }
 
-func (c *canonical) addFloat64(key string, value float64) {
-	c.addFloat(c.normalizeKey(key), c.values, value)
+// addSaturating64 is addSaturating for int64 values.
+func addSaturating64(a, b int64) (int64, bool) {
+	sum := a + b
+	switch {
+	case b > 0 && sum < a:
+		return math.MaxInt64, true
+	case b < 0 && sum > a:
+		return math.MinInt64, true
+	}
+	return sum, false
 }
 
-func (c *canonical) addFloat(parts []string, state *orderedmap.OrderedMap[string, any], value float64) {
-	if len(parts) == 1 {
-		val, ok := state.Get(parts[0])
+// clampInt64 returns v clamped to the range of int, and whether clamping was needed.  It only clamps on platforms
+// where int is narrower than int64.
+func clampInt64(v int64) (int, bool) {
+	switch {
+	case v > math.MaxInt:
+		return math.MaxInt, true
+	case v < math.MinInt:
+		return math.MinInt, true
+	}
+	return int(v), false
+}
+
+// rollup adds value to the total key beside each parent of parts, for WithRollupTotals.  Adds to a total key itself
+// are not rolled up again.  A total that receives both ints and floats is kept as a float64.
+func (c *canonical) rollup(parts []string, value any) {
+	parts = c.truncate(parts)
+	if parts[len(parts)-1] == "total" {
+		return
+	}
+	for i := len(parts) - 1; i > 0; i-- {
+		total := append(parts[:i:i], "total")
+		old, _ := c.get(total)
+		switch v := value.(type) {
+		case int:
+			if _, ok := old.(float64); ok {
+				c.addFloat(total, float64(v))
+				continue
+			}
+			c.add(total, v)
+		case float64:
+			if n, ok := old.(int); ok {
+				c.put(total, float64(n))
+			}
+			c.addFloat(total, v)
+		}
+	}
+}
+
+// addFloat adds value to the float64 at parts, creating it if needed.  It reports whether the value was added, as add
+// does.  A non-finite sum handled by WithNonFiniteFloats still counts as added.
+func (c *canonical) addFloat(parts []string, value float64) bool {
+	if !c.writable() {
+		return false
+	}
+	c.trace("ADD", parts, value)
+	state, leaf := c.container(parts)
+	sum := value
+	if val, ok := state.Get(leaf); ok {
+		vv, ok := val.(float64)
 		if !ok {
-			state.Set(parts[0], value)
+			return false
 		}
-		if vv, ok := val.(float64); ok {
-			state.Set(parts[0], vv+value)
+		sum = vv + value
+	}
+	if !isFinite(sum) {
+		if v, ok := c.nonFinite(parts, sum); ok {
+			state.Set(leaf, v)
 		}
+		return true
+	}
+	state.Set(leaf, sum)
+	return true
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// nonFinite applies the configured NonFinitePolicy to f stored at parts.  It returns the value to store, or false if
+// the key was removed instead.
+func (c *canonical) nonFinite(parts []string, f float64) (any, bool) {
+	switch c.opts.nonFinite {
+	case NonFiniteString:
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	case NonFiniteDrop:
+		c.delete(parts)
+		c.removeEmptyParents(parts)
+		return nil, false
+	case NonFiniteError:
+		c.delete(parts)
+		c.removeEmptyParents(parts)
+		c.recordError(fmt.Errorf("%s: non-finite float %v", strings.Join(parts, "."), f))
+		return nil, false
+	}
+	return f, true
+}
+
+// detached returns a canonical holding values with the same options as c, except that it never reports cardinality
+// warnings, annotates sources, or traces or transforms writes.  It is used for copies of the event that are only
+// rendered or emitted.
+func (c *canonical) detached(values *orderedmap.OrderedMap[string, any]) *canonical { //nolint:typecheck
+	opts := *c.opts
+	opts.cardinalityWarn = nil
+	opts.sourceAnnotations = false
+	opts.traceWriter = nil
+	opts.transforms = nil
+	return &canonical{values: values, opts: &opts}
+}
+
+// snapshot returns a deep copy of the canonical, with its namespaces merged in, that can be modified and marshaled
+// independently.
+func (c *canonical) snapshot() *canonical {
+	values, _ := c.merged()
+	return c.detached(values)
+}
+
+func (c *canonical) marshal() ([]byte, error) {
+	return c.encode(c.render())
+}
+
+// encode marshals v to JSON, leaving <, > and & unescaped if configured by WithHTMLEscape.
+func (c *canonical) encode(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || !c.opts.noHTMLEscape {
+		return b, err
+	}
+	return unescapeHTML(b), nil
+}
+
+// unescapeHTML replaces the \u003c, \u003e and \u0026 escapes that encoding/json emits for <, > and & in b with the
+// characters themselves.  Other escapes are kept.
+func unescapeHTML(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' || i+1 >= len(b) {
+			out = append(out, b[i])
+			continue
+		}
+		if b[i+1] == 'u' && i+6 <= len(b) {
+			switch string(b[i+2 : i+6]) {
+			case "003c":
+				out, i = append(out, '<'), i+5
+				continue
+			case "003e":
+				out, i = append(out, '>'), i+5
+				continue
+			case "0026":
+				out, i = append(out, '&'), i+5
+				continue
+			}
+		}
+		out, i = append(out, b[i], b[i+1]), i+1
+	}
+	return out
+}
+
+// render returns the tree to marshal with empty nested maps pruned and marshal-time options such as redaction applied.
+// The live tree is returned as-is when there is nothing to change.
+func (c *canonical) render() *orderedmap.OrderedMap[string, any] { //nolint:typecheck
+	if len(c.opts.redactKeys) == 0 && len(c.opts.redactUnlessError) == 0 && len(c.opts.aliases) == 0 &&
+		c.opts.maxKeys <= 0 && c.opts.maxEventBytes <= 0 && len(c.namespaces) == 0 && !hasEmptyMap(c.values) {
+		return c.values
+	}
+
+	values, roots := c.merged()
+	out := c.detached(values)
+	prune(out.values)
+	roots = append([][]string{nil}, roots...)
+	redact := func(paths [][]string) {
+		for _, root := range roots {
+			for _, parts := range paths {
+				out.redact(append(root[:len(root):len(root)], parts...))
+			}
+		}
+	}
+	redact(c.opts.redactKeys)
+	if len(c.opts.redactUnlessError) > 0 {
+		if status, _ := out.get([]string{"http", "response", "status_code"}); !isErrorStatus(status) {
+			redact(c.opts.redactUnlessError)
+		}
+	}
+	for _, alias := range c.opts.aliases {
+		out.move(alias.from, alias.to)
+	}
+	if c.opts.maxKeys > 0 {
+		out.limitKeys(c.opts.maxKeys)
+	}
+	if c.opts.maxEventBytes > 0 {
+		out.shrink(c.opts.maxEventBytes)
+	}
+	return out.values
+}
+
+// limitKeys removes all but the first n leaf values and records the number removed in clog.dropped_keys.
+func (c *canonical) limitKeys(n int) {
+	var paths [][]string
+	walk(c.values, nil, func(path []string, _ any) {
+		paths = append(paths, slices.Clone(path))
+	})
+	if len(paths) <= n {
+		return
+	}
+	for _, parts := range paths[n:] {
+		c.delete(parts)
+		c.removeEmptyParents(parts)
+	}
+	c.put([]string{"clog", "dropped_keys"}, len(paths)-n)
+}
+
+// hasEmptyMap reports whether m holds an empty nested map at any depth.
+func hasEmptyMap(m *orderedmap.OrderedMap[string, any]) bool { //nolint:typecheck
+	for pair := m.Oldest(); pair != nil; pair = pair.Next() {
+		if child, ok := pair.Value.(*orderedmap.OrderedMap[string, any]); ok && (child.Len() == 0 || hasEmptyMap(child)) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the nested maps of m that are empty or only hold empty maps.  Maps inside arrays are kept.
+func prune(m *orderedmap.OrderedMap[string, any]) { //nolint:typecheck
+	for pair := m.Oldest(); pair != nil; {
+		next := pair.Next()
+		if child, ok := pair.Value.(*orderedmap.OrderedMap[string, any]); ok {
+			prune(child)
+			if child.Len() == 0 {
+				m.Delete(pair.Key)
+			}
+		}
+		pair = next
+	}
+}
+
+// redact replaces the value at parts, if any, with "[REDACTED]".
+func (c *canonical) redact(parts []string) {
+	if state, leaf := c.lookup(parts); state != nil {
+		if _, ok := state.Get(leaf); ok {
+			state.Set(leaf, "[REDACTED]")
+		}
+	}
+}
+
+// isErrorStatus reports whether status is an HTTP status code of 400 or above.
+func isErrorStatus(status any) bool {
+	code, ok := status.(int)
+	return ok && code >= 400
+}
+
+// shrink removes the most recently added leaf values until the event marshals to at most n bytes, marking the event
+// with clog.truncated.  The marker itself is never removed.  The number of leaves to remove is found by binary search,
+// so the event is marshaled O(log n) times rather than once per removed leaf.
+func (c *canonical) shrink(n int) {
+	truncated := []string{"clog", "truncated"}
+	if b, err := c.encode(c.values); err != nil || len(b) <= n {
 		return
 	}
 
-	val, ok := state.Get(parts[0])
+	var paths [][]string
+	walk(c.values, nil, func(path []string, _ any) {
+		if !slices.Equal(path, truncated) {
+			paths = append(paths, slices.Clone(path))
+		}
+	})
+	dropped := func(values *orderedmap.OrderedMap[string, any], k int) *canonical { //nolint:typecheck
+		out := c.detached(values)
+		for _, parts := range paths[len(paths)-k:] {
+			out.delete(parts)
+			out.removeEmptyParents(parts)
+		}
+		out.put(truncated, true)
+		return out
+	}
+
+	k := sort.Search(len(paths), func(k int) bool {
+		b, err := c.encode(dropped(cloneMap(c.values), k).values)
+		return err == nil && len(b) <= n
+	})
+	c.values = dropped(c.values, min(k, len(paths))).values
+}
+
+// move moves the value at from to the path to.  A key renamed within the same parent keeps its position.
+func (c *canonical) move(from, to []string) {
+	state, leaf := c.lookup(from)
+	if state == nil {
+		return
+	}
+	val, ok := state.Get(leaf)
 	if !ok {
-		val = orderedmap.New[string, any]()
-		state.Set(parts[0], val)
+		return
+	}
+
+	if slices.Equal(from[:len(from)-1], to[:len(to)-1]) {
+		renameKey(state, leaf, to[len(to)-1])
+		return
 	}
-	c.addFloat(parts[1:], val.(*orderedmap.OrderedMap[string, any]), value)
+	state.Delete(leaf)
+	c.removeEmptyParents(from)
+	target, targetLeaf := c.container(to)
+	target.Set(targetLeaf, val)
 }
 
-func (c *canonical) string() string {
-	b, _ := json.Marshal(c.values)
-	return string(b)
+// removeEmptyParents removes the maps along parts that became empty, starting with the deepest.
+func (c *canonical) removeEmptyParents(parts []string) {
+	for i := len(parts) - 1; i > 0; i-- {
+		state, leaf := c.lookup(parts[:i])
+		if state == nil {
+			continue
+		}
+		val, _ := state.Get(leaf)
+		if m, ok := val.(*orderedmap.OrderedMap[string, any]); !ok || m.Len() > 0 {
+			return
+		}
+		state.Delete(leaf)
+	}
+}
+
+// renameKey renames the key from to the key to in m, keeping its position.
+func renameKey(m *orderedmap.OrderedMap[string, any], from, to string) { //nolint:typecheck
+	type pair struct {
+		key   string
+		value any
+	}
+	pairs := make([]pair, 0, m.Len())
+	for p := m.Oldest(); p != nil; p = p.Next() {
+		if p.Key == to {
+			continue
+		}
+		key := p.Key
+		if key == from {
+			key = to
+		}
+		pairs = append(pairs, pair{key: key, value: p.Value})
+	}
+	for _, p := range pairs {
+		m.Delete(p.key)
+	}
+	m.Delete(from)
+	m.Delete(to)
+	for _, p := range pairs {
+		m.Set(p.key, p.value)
+	}
 }
@@ -2,7 +2,14 @@ package clog
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -55,6 +62,37 @@ func TestCanonical_AddFloat64(t *testing.T) {
 	require.Equal(t, `{"foo":{"bar":2.3}}`, MarshalJSON(ctx))
 }
 
+func TestCanonical_Add(t *testing.T) {
+	ctx := Init(context.Background())
+	Add(ctx, "counts.int", 1)
+	Add(ctx, "counts.int", 2)
+	Add(ctx, "counts.int64", int64(3))
+	Add(ctx, "counts.int64", int64(4))
+	Add(ctx, "counts.float64", 0.5)
+	Add(ctx, "counts.float64", 0.25)
+	Add(ctx, "counts.int", int64(10))
+	Add(ctx, "counts.int", 1.5)
+
+	require.Equal(t, `{"counts":{"int":13,"int64":7,"float64":0.75}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_Add_Overflow(t *testing.T) {
+	ctx := Init(context.Background())
+	Add(ctx, "counter", int64(math.MaxInt)-1)
+	Add(ctx, "counter", int64(2))
+	require.Equal(t, fmt.Sprintf(`{"counter":%d,"clog":{"overflow":true}}`, math.MaxInt), MarshalJSON(ctx))
+}
+
+func TestCanonical_Add_Int64Value(t *testing.T) {
+	ctx := Init(context.Background())
+	SetDurationNanos(ctx, "job.duration", 750*time.Nanosecond)
+	Add(ctx, "job.duration", 250)
+	Add(ctx, "job.duration", int64(1000))
+	SetDurationNanos(ctx, "max.duration", time.Duration(math.MaxInt64))
+	Add(ctx, "max.duration", 1)
+	require.Equal(t, fmt.Sprintf(`{"job":{"duration":2000},"max":{"duration":%d},"clog":{"overflow":true}}`, int64(math.MaxInt64)), MarshalJSON(ctx))
+}
+
 func TestCanonical_MultiNested(t *testing.T) {
 	ctx := context.Background()
 	ctx = Init(ctx)
@@ -64,3 +102,391 @@ func TestCanonical_MultiNested(t *testing.T) {
 
 	require.Equal(t, `{"http":{"request":{"path":"/foo","code":"200"},"response":{"duration_ms":10}}}`, MarshalJSON(ctx))
 }
+
+func TestCanonical_Disabled(t *testing.T) {
+	ctx := Disabled(context.Background())
+	ctx = Init(ctx)
+	SetString(ctx, "foo", "bar")
+	SetInt(ctx, "foo.int", 1)
+	SetFloat64(ctx, "foo.float", 1.1)
+	AddInt(ctx, "foo.count", 1)
+	AddFloat64(ctx, "foo.total", 1.1)
+
+	require.Equal(t, "", MarshalJSON(ctx))
+}
+
+func TestCanonical_SetSubEvent(t *testing.T) {
+	ctx := context.Background()
+	ctx = Init(ctx)
+	SetString(ctx, "request_id", "req-123")
+	SetSubEvent(ctx, "downstream.payments", `{"http":{"response":{"status_code":201,"duration_ms":12.5}},"charges":[{"id":"ch_1","amount":100}]}`)
+
+	require.Equal(t, `{"request_id":"req-123","downstream":{"payments":{"http":{"response":{"status_code":201,"duration_ms":12.5}},"charges":[{"id":"ch_1","amount":100}]}}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_SetSubEvent_Invalid(t *testing.T) {
+	ctx := context.Background()
+	ctx = Init(ctx)
+	SetSubEvent(ctx, "downstream.payments", `{"http":`)
+	SetSubEvent(ctx, "downstream.orders", `[1,2]`)
+
+	require.Equal(t, `{"clog":{"error":"SetSubEvent downstream.orders: event is not a JSON object"}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_MarshalJSONBytes(t *testing.T) {
+	ctx := context.Background()
+	b, err := MarshalJSONBytes(ctx)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	ctx = Init(ctx)
+	SetString(ctx, "http.request.method", "GET")
+	b, err = MarshalJSONBytes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, `{"http":{"request":{"method":"GET"}}}`, string(b))
+}
+
+func benchmarkContext() context.Context {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.path", "/example")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetFloat64(ctx, "http.response.duration_ms", 123.45)
+	return ctx
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = MarshalJSON(ctx)
+	}
+}
+
+func BenchmarkMarshalJSONBytes(b *testing.B) {
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = MarshalJSONBytes(ctx)
+	}
+}
+
+func TestCanonical_AddIntPath(t *testing.T) {
+	ctx := context.Background()
+	ctx = Init(ctx)
+	AddIntPath(ctx, 1, "db", "queries", "by_table", "public.users")
+	AddIntPath(ctx, 3, "db", "queries", "by_table", "public.users")
+	AddIntPath(ctx, 1, "db", "queries", "by_table", "Public.Orders")
+	AddIntPath(ctx, 1)
+
+	require.Equal(t, `{"db":{"queries":{"by_table":{"public.users":4,"public.orders":1}}}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_MaxDepth(t *testing.T) {
+	parts := make([]string, 30)
+	for i := range parts {
+		parts[i] = string(rune('a' + i%26))
+	}
+	key := strings.Join(parts, ".")
+
+	ctx := InitWithOptions(context.Background(), WithMaxDepth(3))
+	SetInt(ctx, key, 1)
+	SetInt(ctx, "x.y", 2)
+
+	require.Equal(t, `{"a":{"b":{"`+strings.Join(parts[2:], ".")+`":1}},"x":{"y":2}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_DeepKey(t *testing.T) {
+	parts := make([]string, 500)
+	for i := range parts {
+		parts[i] = "k"
+	}
+
+	ctx := Init(context.Background())
+	SetInt(ctx, strings.Join(parts, "."), 1)
+	require.NotEmpty(t, MarshalJSON(ctx))
+}
+
+func TestCanonical_SetOverwritesScalarParent(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "foo", "bar")
+	SetInt(ctx, "foo.baz", 1)
+
+	require.Equal(t, `{"foo":{"baz":1}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_AddFloat64_NewNestedKey(t *testing.T) {
+	ctx := context.Background()
+	ctx = Init(ctx)
+	AddFloat64(ctx, "a.b.c", 1.5)
+
+	require.Equal(t, `{"a":{"b":{"c":1.5}}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_WithTempFields(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "db.table", "users")
+
+	restore := WithTempFields(ctx, map[string]any{
+		"db.table":     "orders",
+		"db.operation": "select",
+	})
+	require.Equal(t, `{"db":{"table":"orders","operation":"select"}}`, MarshalJSON(ctx))
+
+	restore()
+	require.Equal(t, `{"db":{"table":"users"}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_WithTempFields_Uninitialized(t *testing.T) {
+	restore := WithTempFields(context.Background(), map[string]any{"foo": "bar"})
+	restore()
+}
+
+func TestCanonical_GetOrSet(t *testing.T) {
+	ctx := Init(context.Background())
+	calls := 0
+	compute := func() any {
+		calls++
+		return "computed"
+	}
+
+	require.Equal(t, "computed", GetOrSet(ctx, "user.tier", compute))
+	require.Equal(t, "computed", GetOrSet(ctx, "user.tier", compute))
+	require.Equal(t, 1, calls)
+
+	SetString(ctx, "user.region", "us-east")
+	require.Equal(t, "us-east", GetOrSet(ctx, "user.region", compute))
+	require.Equal(t, 1, calls)
+	require.Equal(t, `{"user":{"tier":"computed","region":"us-east"}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_GetOrSet_Concurrent(t *testing.T) {
+	ctx := Init(context.Background())
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			GetOrSet(ctx, "expensive", func() any {
+				calls.Add(1)
+				return 42
+			})
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load())
+	require.Equal(t, `{"expensive":42}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_AddMap(t *testing.T) {
+	ctx := Init(context.Background())
+	SetInt(ctx, "db.queries.select", 2)
+	SetFloat64(ctx, "db.duration_ms", 1.5)
+
+	AddMap(ctx, "db", map[string]any{
+		"queries": map[string]any{
+			"select": 3,
+			"insert": int64(1),
+		},
+		"duration_ms": 2.0,
+		"driver":      "postgres",
+	})
+	AddMap(ctx, "", map[string]any{"cache": map[string]any{"hits": 1}})
+
+	require.Equal(t, `{"db":{"queries":{"select":5,"insert":1},"duration_ms":3.5,"driver":"postgres"},"cache":{"hits":1}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_SwapAndMarshal(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "foo", "bar")
+
+	require.Equal(t, `{"foo":"bar"}`, SwapAndMarshal(ctx))
+	require.Equal(t, `{}`, MarshalJSON(ctx))
+	require.Equal(t, "", SwapAndMarshal(context.Background()))
+}
+
+func TestCanonical_SwapAndMarshal_Concurrent(t *testing.T) {
+	ctx := Init(context.Background())
+	const writers, writes = 4, 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				AddInt(ctx, "n", 1)
+			}
+		}()
+	}
+
+	var events []string
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			events = append(events, SwapAndMarshal(ctx))
+		}
+	}
+	events = append(events, SwapAndMarshal(ctx))
+
+	total := 0
+	for _, event := range events {
+		var decoded struct {
+			N int `json:"n"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+		total += decoded.N
+	}
+	require.Equal(t, writers*writes, total)
+}
+
+func TestCanonical_SetMaxMin(t *testing.T) {
+	ctx := Init(context.Background())
+	for _, v := range []int{12, 48, 3, 20} {
+		SetMaxInt(ctx, "db.slowest_query_ms", v)
+		SetMinInt(ctx, "db.fastest_query_ms", v)
+	}
+	for _, v := range []float64{1.5, 0.25, 9.75, 2} {
+		SetMaxFloat64(ctx, "payload.largest_kb", v)
+		SetMinFloat64(ctx, "payload.smallest_kb", v)
+	}
+
+	require.Equal(t, `{"db":{"slowest_query_ms":48,"fastest_query_ms":3},"payload":{"largest_kb":9.75,"smallest_kb":0.25}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_SetStringf(t *testing.T) {
+	ctx := Init(context.Background())
+	SetStringf(ctx, "job.name", "import-%s-%03d", "users", 7)
+
+	expected := Init(context.Background())
+	SetString(expected, "job.name", fmt.Sprintf("import-%s-%03d", "users", 7))
+
+	require.Equal(t, MarshalJSON(expected), MarshalJSON(ctx))
+	require.Equal(t, `{"job":{"name":"import-users-007"}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_MarshalSubtreeJSON(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRedactKeys("http.request.token"))
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.token", "secret")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetString(ctx, "user.id", "42")
+
+	require.Equal(t, `{"request":{"method":"GET","token":"[REDACTED]"},"response":{"status_code":200}}`, MarshalSubtreeJSON(ctx, "http"))
+	require.Equal(t, `{"status_code":200}`, MarshalSubtreeJSON(ctx, "http.response"))
+	require.Equal(t, "", MarshalSubtreeJSON(ctx, "user.id"))
+	require.Equal(t, "", MarshalSubtreeJSON(ctx, "missing"))
+	require.Equal(t, "", MarshalSubtreeJSON(context.Background(), "http"))
+}
+
+func TestCanonical_SetDuration(t *testing.T) {
+	ctx := Init(context.Background())
+	SetDuration(ctx, "db.duration_ms", 1200*time.Millisecond)
+	require.Equal(t, `{"db":{"duration_ms":1200}}`, MarshalJSON(ctx))
+
+	ctx = InitWithOptions(context.Background(), WithDurationStringFormat())
+	SetDuration(ctx, "db.duration", 1200*time.Millisecond)
+	SetDuration(ctx, "cache.duration", 123*time.Millisecond)
+	require.Equal(t, `{"db":{"duration":"1.2s"},"cache":{"duration":"123ms"}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_AddInt_Overflow(t *testing.T) {
+	ctx := Init(context.Background())
+	SetInt(ctx, "counter", math.MaxInt-1)
+	AddInt(ctx, "counter", 1)
+	require.Equal(t, fmt.Sprintf(`{"counter":%d}`, math.MaxInt), MarshalJSON(ctx))
+
+	AddInt(ctx, "counter", 5)
+	SetInt(ctx, "low", math.MinInt+1)
+	AddInt(ctx, "low", -2)
+	require.Equal(t, fmt.Sprintf(`{"counter":%d,"clog":{"overflow":true},"low":%d}`, math.MaxInt, math.MinInt), MarshalJSON(ctx))
+}
+
+func TestCanonical_SetFloat32(t *testing.T) {
+	ctx := Init(context.Background())
+	SetFloat32(ctx, "model.score", 0.1)
+	SetFloat32(ctx, "model.threshold", 1.0/3)
+	AddFloat32(ctx, "model.total", 0.1)
+	AddFloat32(ctx, "model.total", 0.2)
+	require.Equal(t, `{"model":{"score":0.1,"threshold":0.33333334,"total":0.30000000000000004}}`, MarshalJSON(ctx))
+
+	// A direct conversion keeps the float32 rounding error.
+	require.NotEqual(t, 0.1, float64(float32(0.1)))
+}
+
+func TestCanonical_Prune(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "user.id", "42")
+	SetSubEvent(ctx, "downstream.payments", `{"meta":{}}`)
+	restore := WithTempFields(ctx, map[string]any{"db.query.table": "users"})
+	restore()
+	AppendObject(ctx, "items")
+
+	require.Equal(t, `{"user":{"id":"42"},"items":[{}]}`, MarshalJSON(ctx))
+
+	var paths []string
+	Walk(ctx, func(path []string, _ any) { paths = append(paths, strings.Join(path, ".")) })
+	require.Equal(t, []string{"user.id", "items"}, paths)
+	_, ok := Snapshot(ctx).Get("db")
+	require.True(t, ok)
+
+	Prune(ctx)
+	_, ok = Snapshot(ctx).Get("db")
+	require.False(t, ok)
+	require.Equal(t, `{"user":{"id":"42"},"items":[{}]}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_SetDurationNanos(t *testing.T) {
+	ctx := Init(context.Background())
+	SetDurationNanos(ctx, "cache.duration", 750*time.Nanosecond)
+	SetDurationNanos(ctx, "job.duration", 104*24*time.Hour+time.Nanosecond)
+	require.Equal(t, `{"cache":{"duration":750},"job":{"duration":8985600000000001}}`, MarshalJSON(ctx))
+}
+
+func TestCanonical_Seal(t *testing.T) {
+	ctx := Init(context.Background())
+	cache := InitNamespace(ctx, "cache")
+	SetString(ctx, "user.id", "42")
+	Seal(ctx)
+
+	SetString(ctx, "user.id", "43")
+	AddInt(ctx, "db.queries", 1)
+	AppendObject(ctx, "items", "id", 1)
+	SetString(cache, "status", "hit")
+	require.Equal(t, `{"user":{"id":"42"},"clog":{"write_after_seal_count":3},"cache":{"clog":{"write_after_seal_count":1}}}`, MarshalJSON(ctx))
+	require.Equal(t, `{"clog":{"write_after_seal_count":1}}`, MarshalJSON(cache))
+
+	strict := InitWithOptions(context.Background(), WithStrictSeal())
+	Seal(strict)
+	require.PanicsWithValue(t, "clog: write after Seal", func() { SetString(strict, "user.id", "42") })
+}
+
+func TestRegisterGlobalProvider(t *testing.T) {
+	t.Cleanup(func() { providers = nil })
+	RegisterGlobalProvider(func(ctx context.Context) {
+		SetString(ctx, "build.sha", "abc123")
+		SetString(ctx, "build.order", "first")
+	})
+	RegisterGlobalProvider(func(ctx context.Context) {
+		SetString(ctx, "build.order", "second")
+	})
+
+	ctx := Init(context.Background())
+	require.Equal(t, `{"build":{"sha":"abc123","order":"second"}}`, MarshalJSON(ctx))
+
+	SetString(ctx, "build.sha", "changed")
+	ctx = Init(ctx)
+	require.Equal(t, `{"build":{"sha":"changed","order":"second"}}`, MarshalJSON(ctx))
+
+	ctx = InitWithOptions(context.Background(), WithWritePolicy(FirstWins))
+	require.Equal(t, `{"build":{"sha":"abc123","order":"first"}}`, MarshalJSON(ctx))
+}
@@ -0,0 +1,18 @@
+package clog
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// ServeAndCapture serves req with handler wrapped in a CanonicalLogger configured with opts and returns the emitted
+// event along with the recorded response.  If more than one event is emitted, such as with WithPeriodicFlush, the
+// last event is returned.  It is intended for tests.
+func ServeAndCapture(handler http.Handler, req *http.Request, opts ...Option) (string, *httptest.ResponseRecorder) {
+	var event string
+	logger := NewCanonicalLogger(handler, func(log string) { event = log }, opts...)
+
+	w := httptest.NewRecorder()
+	logger.ServeHTTP(w, req)
+	return event, w
+}
@@ -0,0 +1,23 @@
+package clog
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeAndCapture(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "123")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	})
+
+	req, err := http.NewRequest("POST", "/users", nil)
+	require.NoError(t, err)
+	event, w := ServeAndCapture(handler, req, WithStatusClass())
+	require.JSONEq(t, `{"user":{"id":"123"},"http":{"version":"1.1","request":{"method":"POST","path":"/users","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":7,"status_code":201,"status_class":"2xx"}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "created", w.Body.String())
+}
@@ -0,0 +1,35 @@
+package clog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Checkpoint records the current time under name so the elapsed time can later be set with SinceCheckpoint.  Recording
+// a checkpoint again replaces the earlier time.  Checkpoints are not part of the event.
+func Checkpoint(ctx context.Context, name string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.checkpoints == nil {
+			c.checkpoints = map[string]time.Time{}
+		}
+		c.checkpoints[name] = c.opts.now()
+	}
+}
+
+// SinceCheckpoint sets key to the number of milliseconds elapsed since the checkpoint recorded under name.  If no such
+// checkpoint exists, the error is recorded in clog.error.
+func SinceCheckpoint(ctx context.Context, name, key string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		start, ok := c.checkpoints[name]
+		if !ok {
+			c.recordError(fmt.Errorf("SinceCheckpoint %s: unknown checkpoint", name))
+			return
+		}
+		c.setInt(key, int(c.opts.now().Sub(start).Milliseconds()))
+	}
+}
@@ -0,0 +1,31 @@
+package clog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinceCheckpoint(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := InitWithOptions(context.Background(), WithClock(func() time.Time { return now }))
+
+	Checkpoint(ctx, "db")
+	now = now.Add(25 * time.Millisecond)
+	SinceCheckpoint(ctx, "db", "db.duration_ms")
+	now = now.Add(10 * time.Millisecond)
+	Checkpoint(ctx, "cache")
+	now = now.Add(5 * time.Millisecond)
+	SinceCheckpoint(ctx, "cache", "cache.duration_ms")
+	SinceCheckpoint(ctx, "db", "total_ms")
+
+	require.Equal(t, `{"db":{"duration_ms":25},"cache":{"duration_ms":5},"total_ms":40}`, MarshalJSON(ctx))
+}
+
+func TestSinceCheckpoint_Unknown(t *testing.T) {
+	ctx := Init(context.Background())
+	SinceCheckpoint(ctx, "missing", "elapsed_ms")
+	require.Equal(t, `{"clog":{"error":"SinceCheckpoint missing: unknown checkpoint"}}`, MarshalJSON(ctx))
+}
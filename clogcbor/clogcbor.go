@@ -0,0 +1,16 @@
+// Package clogcbor encodes canonical logging contexts as CBOR (RFC 8949).  It is a separate package so that programs
+// that only emit JSON do not link the CBOR encoder.
+package clogcbor
+
+import (
+	"context"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jwilder/clog"
+)
+
+// MarshalCBOR returns the canonical logging context encoded as CBOR.  Nested keys are encoded as nested maps.  It
+// returns nil if the context is not initialized.
+func MarshalCBOR(ctx context.Context) ([]byte, error) {
+	return clog.MarshalWith(ctx, cbor.Marshal)
+}
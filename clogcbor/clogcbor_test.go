@@ -0,0 +1,39 @@
+package clogcbor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jwilder/clog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalCBOR(t *testing.T) {
+	ctx := clog.InitWithOptions(context.Background(), clog.WithRedactKeys("user.email"))
+	clog.SetString(ctx, "http.request.method", "GET")
+	clog.SetInt(ctx, "http.response.status_code", 200)
+	clog.SetFloat64(ctx, "http.response.duration_ms", 12.5)
+	clog.SetString(ctx, "user.email", "alice@example.com")
+	clog.AppendObject(ctx, "db.queries", "table", "users", "rows", 3)
+
+	b, err := MarshalCBOR(ctx)
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, cbor.Unmarshal(b, &decoded))
+	require.Equal(t, map[string]any{
+		"http": map[any]any{
+			"request":  map[any]any{"method": "GET"},
+			"response": map[any]any{"status_code": uint64(200), "duration_ms": 12.5},
+		},
+		"user": map[any]any{"email": "[REDACTED]"},
+		"db":   map[any]any{"queries": []any{map[any]any{"table": "users", "rows": uint64(3)}}},
+	}, decoded)
+}
+
+func TestMarshalCBOR_Uninitialized(t *testing.T) {
+	b, err := MarshalCBOR(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, b)
+}
@@ -0,0 +1,16 @@
+// Package clogmsgpack encodes canonical logging contexts as MessagePack.  It is a separate package so that programs
+// that only emit JSON do not link the MessagePack encoder.
+package clogmsgpack
+
+import (
+	"context"
+
+	"github.com/jwilder/clog"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MarshalMsgpack returns the canonical logging context encoded as MessagePack.  Nested keys are encoded as nested
+// maps.  It returns nil if the context is not initialized.
+func MarshalMsgpack(ctx context.Context) ([]byte, error) {
+	return clog.MarshalWith(ctx, msgpack.Marshal)
+}
@@ -0,0 +1,37 @@
+package clogmsgpack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jwilder/clog"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMarshalMsgpack(t *testing.T) {
+	ctx := clog.InitWithOptions(context.Background(), clog.WithRedactKeys("user.email"))
+	clog.SetString(ctx, "http.request.method", "GET")
+	clog.SetInt(ctx, "http.response.status_code", 200)
+	clog.SetFloat64(ctx, "http.response.duration_ms", 12.5)
+	clog.SetString(ctx, "user.email", "alice@example.com")
+
+	b, err := MarshalMsgpack(ctx)
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, msgpack.Unmarshal(b, &decoded))
+	require.Equal(t, map[string]any{
+		"http": map[string]any{
+			"request":  map[string]any{"method": "GET"},
+			"response": map[string]any{"status_code": uint8(200), "duration_ms": 12.5},
+		},
+		"user": map[string]any{"email": "[REDACTED]"},
+	}, decoded)
+}
+
+func TestMarshalMsgpack_Uninitialized(t *testing.T) {
+	b, err := MarshalMsgpack(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, b)
+}
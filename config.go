@@ -0,0 +1,76 @@
+package clog
+
+import (
+	"context"
+	"net/http"
+)
+
+// Config is an alternative to functional options for configuring the canonical logging context and the
+// CanonicalLogger middleware.  Zero values leave the corresponding setting at its default.  There is no key delimiter
+// setting: keys are always split on ".", which the middleware and the ECS mapping rely on for the fields they record.
+type Config struct {
+	// MaxDepth limits the nesting depth of keys, see WithMaxDepth.
+	MaxDepth int
+	// MaxKeys limits the number of values in the marshaled event, see WithMaxKeys.
+	MaxKeys int
+	// MaxValueBytes limits the size of string values, see WithMaxValueBytes.
+	MaxValueBytes int
+	// MaxEventBytes limits the size of the marshaled event, see WithMaxEventBytes.
+	MaxEventBytes int
+	// SampleRate is the fraction of requests the middleware logs, see WithSampleRate.  Zero logs every request; to log
+	// none, pass WithSampleRate(0) to NewCanonicalLoggerWithConfig instead.
+	SampleRate float64
+	// SanitizeValues removes control characters from string values, see WithSanitizeValues.
+	SanitizeValues bool
+	// RedactKeys lists keys whose values are redacted, see WithRedactKeys.
+	RedactKeys []string
+	// KeyAliases renames keys when the event is marshaled, see WithKeyAliases.
+	KeyAliases map[string]string
+	// WritePolicy controls writes to existing keys, see WithWritePolicy.
+	WritePolicy WritePolicy
+}
+
+// Options returns the functional options equivalent to cfg.
+func (cfg Config) Options() []Option {
+	var opts []Option
+	if cfg.MaxDepth != 0 {
+		opts = append(opts, WithMaxDepth(cfg.MaxDepth))
+	}
+	if cfg.MaxKeys != 0 {
+		opts = append(opts, WithMaxKeys(cfg.MaxKeys))
+	}
+	if cfg.MaxValueBytes != 0 {
+		opts = append(opts, WithMaxValueBytes(cfg.MaxValueBytes))
+	}
+	if cfg.MaxEventBytes != 0 {
+		opts = append(opts, WithMaxEventBytes(cfg.MaxEventBytes))
+	}
+	if cfg.SampleRate != 0 {
+		opts = append(opts, WithSampleRate(cfg.SampleRate))
+	}
+	if cfg.SanitizeValues {
+		opts = append(opts, WithSanitizeValues())
+	}
+	if len(cfg.RedactKeys) > 0 {
+		opts = append(opts, WithRedactKeys(cfg.RedactKeys...))
+	}
+	if len(cfg.KeyAliases) > 0 {
+		opts = append(opts, WithKeyAliases(cfg.KeyAliases))
+	}
+	if cfg.WritePolicy != LastWins {
+		opts = append(opts, WithWritePolicy(cfg.WritePolicy))
+	}
+	return opts
+}
+
+// InitWithConfig initializes the canonical logging context configured by cfg.  It is equivalent to InitWithOptions
+// with cfg.Options().
+func InitWithConfig(ctx context.Context, cfg Config) context.Context {
+	return InitWithOptions(ctx, cfg.Options()...)
+}
+
+// NewCanonicalLoggerWithConfig returns a CanonicalLogger middleware configured by cfg.  Additional options are applied
+// after those derived from cfg.
+func NewCanonicalLoggerWithConfig(wrapped http.Handler, logFn func(string), cfg Config, opts ...Option) http.Handler {
+	return NewCanonicalLogger(wrapped, logFn, append(cfg.Options(), opts...)...)
+}
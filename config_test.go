@@ -0,0 +1,76 @@
+package clog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitWithConfig(t *testing.T) {
+	ctx := InitWithConfig(context.Background(), Config{
+		MaxKeys:       3,
+		MaxValueBytes: 5,
+		RedactKeys:    []string{"user.email"},
+		KeyAliases:    map[string]string{"user.id": "user.uid"},
+	})
+	SetString(ctx, "user.id", "42")
+	SetString(ctx, "user.email", "alice@example.com")
+	SetString(ctx, "note", strings.Repeat("x", 10))
+	SetInt(ctx, "db.rows", 3)
+	SetInt(ctx, "db.queries", 1)
+	require.Equal(t, `{"user":{"uid":"42","email":"[REDACTED]"},"note":"xxxxx","clog":{"dropped_keys":2}}`, MarshalJSON(ctx))
+}
+
+func TestNewCanonicalLoggerWithConfig(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "42")
+		SetString(r.Context(), "user.id", "43")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var event string
+	logger := NewCanonicalLoggerWithConfig(handler, func(s string) { event = s }, Config{WritePolicy: FirstWins}, WithStatusClass())
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	logger.ServeHTTP(httptest.NewRecorder(), req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200,"status_class":"2xx"}},"user":{"id":"42"},"result":"ok"}`, event)
+}
+
+func TestConfig_Options(t *testing.T) {
+	require.Empty(t, Config{}.Options())
+
+	ctx := InitWithConfig(context.Background(), Config{MaxDepth: 2})
+	SetString(ctx, "a.b.c.d", "x")
+	require.Equal(t, `{"a":{"b.c.d":"x"}}`, MarshalJSON(ctx))
+}
+
+func TestNewCanonicalLoggerWithConfig_SampleRate(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		cfg    Config
+		opts   []Option
+		logged bool
+	}{
+		{name: "zero logs every request", cfg: Config{}, logged: true},
+		{name: "one logs every request", cfg: Config{SampleRate: 1}, logged: true},
+		{name: "tiny rate logs nothing", cfg: Config{SampleRate: 1e-300}, logged: false},
+		{name: "option logs nothing", cfg: Config{}, opts: []Option{WithSampleRate(0)}, logged: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bool
+			logger := NewCanonicalLoggerWithConfig(handler, func(string) { logged = true }, tt.cfg, tt.opts...)
+			req, err := http.NewRequest("GET", "/test", nil)
+			require.NoError(t, err)
+			logger.ServeHTTP(httptest.NewRecorder(), req)
+			require.Equal(t, tt.logged, logged)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package clog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wk8/go-ordered-map/v2"
+)
+
+// Parse decodes a previously emitted event into a new canonical logging context derived from context.Background.  The
+// key order of the event is preserved, so marshaling the returned context reproduces the event.  Values can be added to
+// the context as usual, which allows events to be re-ingested or merged.
+func Parse(eventJSON string) (context.Context, error) {
+	values, err := decodeObject([]byte(eventJSON))
+	if err != nil {
+		return nil, fmt.Errorf("clog: parse event: %w", err)
+	}
+	c := newCanonical(nil)
+	c.values = values
+	return context.WithValue(context.Background(), contextKey, c), nil
+}
+
+// decodeObject decodes a JSON object into nested ordered maps so that the key order of the original document is
+// preserved.  Integral numbers are decoded as int and all other numbers as float64.
+func decodeObject(data []byte) (*orderedmap.OrderedMap[string, any], error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(*orderedmap.OrderedMap[string, any])
+	if !ok {
+		return nil, errors.New("event is not a JSON object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after event")
+	}
+	return m, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			m := orderedmap.New[string, any]()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(keyTok.(string), val)
+			}
+			_, err := dec.Token()
+			return m, err
+		case '[':
+			arr := []any{}
+			for dec.More() {
+				val, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, val)
+			}
+			_, err := dec.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %q", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i), nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
@@ -0,0 +1,41 @@
+package clog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetFloat64(ctx, "db.duration", 1.5)
+	AppendObject(ctx, "items", "id", 1)
+	event := MarshalJSON(ctx)
+
+	parsed, err := Parse(event)
+	require.NoError(t, err)
+	require.Equal(t, event, MarshalJSON(parsed))
+
+	AddInt(parsed, "http.response.status_code", 1)
+	SetString(parsed, "replayed", "true")
+	require.Equal(t, `{"http":{"request":{"method":"GET"},"response":{"status_code":201}},"db":{"duration":1.5},"items":[{"id":1}],"replayed":"true"}`, MarshalJSON(parsed))
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse(`[1,2]`)
+	require.EqualError(t, err, "clog: parse event: event is not a JSON object")
+
+	_, err = Parse(`{"a":`)
+	require.Error(t, err)
+
+	for _, event := range []string{`{"a":1} garbage`, `{"a":1}{"b":2}`, `{"a":1} 2`} {
+		_, err = Parse(event)
+		require.EqualError(t, err, "clog: parse event: unexpected data after event", event)
+	}
+
+	_, err = Parse("{\"a\":1}\n")
+	require.NoError(t, err)
+}
@@ -0,0 +1,42 @@
+package clog
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// DiffEntry describes how the value at a key differs between two events.  Old is nil for keys that were added and New
+// is nil for keys that were removed.
+type DiffEntry struct {
+	Old any
+	New any
+}
+
+// Diff compares the leaf values of two canonical logging contexts and returns the keys that were added, removed or
+// changed from a to b, keyed by their dot-separated path.  Arrays are compared as a whole.  An uninitialized context
+// is treated as an empty event.  This is useful in tests to assert exactly what a handler modified.
+func Diff(a, b context.Context) map[string]DiffEntry {
+	before, after := leaves(a), leaves(b)
+	diff := map[string]DiffEntry{}
+	for key, old := range before {
+		if val, ok := after[key]; !ok || !reflect.DeepEqual(old, val) {
+			diff[key] = DiffEntry{Old: old, New: val}
+		}
+	}
+	for key, val := range after {
+		if _, ok := before[key]; !ok {
+			diff[key] = DiffEntry{New: val}
+		}
+	}
+	return diff
+}
+
+// leaves returns the leaf values of the canonical logging context in ctx keyed by their dot-separated path.
+func leaves(ctx context.Context) map[string]any {
+	out := map[string]any{}
+	Walk(ctx, func(path []string, value any) {
+		out[strings.Join(path, ".")] = toPlain(cloneValue(value))
+	})
+	return out
+}
@@ -0,0 +1,42 @@
+package clog
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "user.id", "42")
+	SetInt(ctx, "db.queries", 1)
+	SetString(ctx, "cache.status", "cold")
+	before, err := Parse(MarshalJSON(ctx))
+	require.NoError(t, err)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		AddInt(ctx, "db.queries", 2)
+		AppendObject(ctx, "db.tables", "name", "users")
+		SetString(ctx, "cache.status", "hit")
+		SetString(ctx, "user.id", "42")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	require.NoError(t, err)
+	handler(nil, req)
+
+	require.Equal(t, map[string]DiffEntry{
+		"db.queries":   {Old: 1, New: 3},
+		"db.tables":    {New: []any{map[string]any{"name": "users"}}},
+		"cache.status": {Old: "cold", New: "hit"},
+	}, Diff(before, ctx))
+
+	removed := Init(context.Background())
+	SetString(removed, "user.id", "42")
+	require.Equal(t, map[string]DiffEntry{
+		"user.id": {Old: "42"},
+	}, Diff(removed, Init(context.Background())))
+	require.Empty(t, Diff(ctx, ctx))
+}
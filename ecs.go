@@ -0,0 +1,64 @@
+package clog
+
+import (
+	"context"
+	"strings"
+)
+
+// ecsFields maps the fields recorded by the CanonicalLogger middleware to their Elastic Common Schema equivalents.
+var ecsFields = []struct {
+	from string
+	to   string
+}{
+	{"http.request.path", "url.path"},
+	// Bodies captured with WithCaptureBodies are moved aside first so that body.bytes does not replace them.
+	{"http.request.body", "http.request.body.content"},
+	{"http.response.body", "http.response.body.content"},
+	{"http.request.body_bytes", "http.request.body.bytes"},
+	{"http.response.body_bytes", "http.response.body.bytes"},
+	{"http.request.user_agent.original", "user_agent.original"},
+	{"http.request.user_agent.browser", "user_agent.name"},
+	{"http.request.user_agent.os", "user_agent.os.name"},
+	{"http.request.user_agent.device", "user_agent.device.name"},
+}
+
+// MarshalECS returns the canonical logging context as a JSON string using Elastic Common Schema field names for the
+// fields recorded by the CanonicalLogger middleware.  http.response.duration_ms is converted to event.duration in
+// nanoseconds.  Fields without an ECS equivalent are emitted unchanged.  The middleware does not record the client
+// address, so there is no source.ip mapping; handlers that want it can set source.ip themselves.  It returns an empty string if the context is
+// not initialized or cannot be marshaled.
+func MarshalECS(ctx context.Context) string {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return ""
+	}
+	c.mu.Lock()
+	ecs := c.detached(cloneMap(c.render()))
+	c.mu.Unlock()
+
+	durationParts := strings.Split("http.response.duration_ms", ".")
+	if state, leaf := ecs.lookup(durationParts); state != nil {
+		if v, ok := state.Get(leaf); ok {
+			state.Delete(leaf)
+			ecs.removeEmptyParents(durationParts)
+			var ns int64
+			switch ms := v.(type) {
+			case int:
+				ns = int64(ms) * 1e6
+			case float64:
+				ns = int64(ms * 1e6)
+			}
+			target, targetLeaf := ecs.container(strings.Split("event.duration", "."))
+			target.Set(targetLeaf, ns)
+		}
+	}
+	for _, field := range ecsFields {
+		ecs.move(strings.Split(field.from, "."), strings.Split(field.to, "."))
+	}
+
+	b, err := ecs.encode(ecs.values)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
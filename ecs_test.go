@@ -0,0 +1,59 @@
+package clog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalECS(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.path", "/test")
+	SetString(ctx, "http.request.user_agent.original", "curl/8.4.0")
+	SetString(ctx, "http.request.user_agent.browser", "curl")
+	SetString(ctx, "http.request.user_agent.os", "other")
+	SetString(ctx, "http.request.user_agent.device", "other")
+	SetInt(ctx, "http.response.duration_ms", 12)
+	SetInt(ctx, "http.request.body_bytes", 0)
+	SetInt(ctx, "http.response.body_bytes", 2)
+	SetInt(ctx, "http.response.status_code", 200)
+	SetString(ctx, "user.id", "123")
+
+	require.JSONEq(t, `{
+		"http":{"request":{"method":"GET","body":{"bytes":0}},"response":{"status_code":200,"body":{"bytes":2}}},
+		"url":{"path":"/test"},
+		"user_agent":{"original":"curl/8.4.0","name":"curl","os":{"name":"other"},"device":{"name":"other"}},
+		"event":{"duration":12000000},
+		"user":{"id":"123"}
+	}`, MarshalECS(ctx))
+
+	// The live event keeps the canonical field names.
+	require.Contains(t, MarshalJSON(ctx), `"duration_ms":12`)
+}
+
+func TestMarshalECS_DurationOnly(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithWritePolicy(FirstWins))
+	SetInt(ctx, "http.response.duration_ms", 5)
+	SetInt(ctx, "event.duration", 1)
+
+	require.Equal(t, `{"event":{"duration":5000000}}`, MarshalECS(ctx))
+}
+
+func TestMarshalECS_CapturedBodies(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.body", `{"name":"a"}`)
+	SetInt(ctx, "http.request.body_bytes", 12)
+	SetString(ctx, "http.response.body", "created")
+	SetInt(ctx, "http.response.body_bytes", 7)
+
+	require.JSONEq(t, `{"http":{
+		"request":{"body":{"content":"{\"name\":\"a\"}","bytes":12}},
+		"response":{"body":{"content":"created","bytes":7}}
+	}}`, MarshalECS(ctx))
+}
+
+func TestMarshalECS_Uninitialized(t *testing.T) {
+	require.Equal(t, "", MarshalECS(context.Background()))
+}
@@ -0,0 +1,75 @@
+package clog
+
+import (
+	"context"
+	"strings"
+)
+
+// Event is a read-only view of a canonical logging context taken by Snapshot.  It is a consistent copy, so later
+// changes to the context are not reflected in it.  Keys are dot-separated like the keys passed to the setters.
+type Event struct {
+	c *canonical
+}
+
+// Snapshot returns a copy of the canonical logging context for inspecting values programmatically.  Namespaces are
+// included under their names.  The copy is taken while the context is locked.  If the context is not initialized, an
+// empty Event is returned.
+func Snapshot(ctx context.Context) Event {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return Event{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Event{c: c.snapshot()}
+}
+
+// Get returns the value stored at key.  Nested values are returned as map[string]any.
+func (e Event) Get(key string) (any, bool) {
+	if e.c == nil {
+		return nil, false
+	}
+	v, ok := e.c.get(e.c.normalizeKey(key))
+	return toPlain(v), ok
+}
+
+// String returns the string stored at key.  It returns false if the key does not exist or is not a string.
+func (e Event) String(key string) (string, bool) {
+	v, ok := e.Get(key)
+	s, isString := v.(string)
+	return s, ok && isString
+}
+
+// Int returns the int stored at key.  It returns false if the key does not exist or is not an int.
+func (e Event) Int(key string) (int, bool) {
+	v, ok := e.Get(key)
+	i, isInt := v.(int)
+	return i, ok && isInt
+}
+
+// Float64 returns the float64 stored at key.  It returns false if the key does not exist or is not a float64.
+func (e Event) Float64(key string) (float64, bool) {
+	v, ok := e.Get(key)
+	f, isFloat := v.(float64)
+	return f, ok && isFloat
+}
+
+// Keys returns the dot-separated keys of all leaf values in insertion order.
+func (e Event) Keys() []string {
+	if e.c == nil {
+		return nil
+	}
+	var keys []string
+	walk(e.c.values, nil, func(path []string, _ any) {
+		keys = append(keys, strings.Join(path, "."))
+	})
+	return keys
+}
+
+// Map returns the event as nested maps.  The returned map is a copy and may be modified.
+func (e Event) Map() map[string]any {
+	if e.c == nil {
+		return map[string]any{}
+	}
+	return toPlain(e.c.values).(map[string]any)
+}
@@ -0,0 +1,61 @@
+package clog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetFloat64(ctx, "http.response.duration_ms", 12.5)
+	SetString(ctx, "user.id", "123")
+
+	event := Snapshot(ctx)
+	SetString(ctx, "user.id", "456")
+
+	method, ok := event.String("HTTP.Request.Method")
+	require.True(t, ok)
+	require.Equal(t, "GET", method)
+
+	status, ok := event.Int("http.response.status_code")
+	require.True(t, ok)
+	require.Equal(t, 200, status)
+
+	duration, ok := event.Float64("http.response.duration_ms")
+	require.True(t, ok)
+	require.Equal(t, 12.5, duration)
+
+	userID, ok := event.String("user.id")
+	require.True(t, ok)
+	require.Equal(t, "123", userID)
+
+	_, ok = event.Int("http.request.method")
+	require.False(t, ok)
+	_, ok = event.String("missing.key")
+	require.False(t, ok)
+
+	response, ok := event.Get("http.response")
+	require.True(t, ok)
+	require.Equal(t, map[string]any{"status_code": 200, "duration_ms": 12.5}, response)
+
+	require.Equal(t, []string{"http.request.method", "http.response.status_code", "http.response.duration_ms", "user.id"}, event.Keys())
+	require.Equal(t, map[string]any{
+		"http": map[string]any{
+			"request":  map[string]any{"method": "GET"},
+			"response": map[string]any{"status_code": 200, "duration_ms": 12.5},
+		},
+		"user": map[string]any{"id": "123"},
+	}, event.Map())
+}
+
+func TestSnapshot_Uninitialized(t *testing.T) {
+	event := Snapshot(context.Background())
+	_, ok := event.String("foo")
+	require.False(t, ok)
+	require.Empty(t, event.Keys())
+	require.Empty(t, event.Map())
+}
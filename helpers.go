@@ -0,0 +1,210 @@
+package clog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/wk8/go-ordered-map/v2"
+)
+
+// AppendObject appends an object to the array at key.  The object is built from alternating keys and values, like
+// slog, and its keys are marshaled in the order given so output is stable.  Keys that are not strings are formatted
+// with fmt.Sprint and a trailing key without a value is dropped.
+//
+//	clog.AppendObject(ctx, "db.queries", "table", "users", "rows", 3)
+func AppendObject(ctx context.Context, key string, keyvals ...any) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.append(c.normalizeKey(key), newObject(keyvals...))
+	}
+}
+
+// newObject builds an ordered map from alternating keys and values.
+func newObject(keyvals ...any) *orderedmap.OrderedMap[string, any] { //nolint:typecheck
+	obj := orderedmap.New[string, any]() //nolint:typecheck
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		obj.Set(strings.ToLower(key), keyvals[i+1])
+	}
+	return obj
+}
+
+// AddValidationError records a field validation error.  Each error is appended as a {"field","message"} object to the
+// array at http.response.validation_errors and http.response.validation_error_count is incremented.
+func AddValidationError(ctx context.Context, field, message string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		obj := newObject("field", field, "message", message)
+		c.append(c.normalizeKey("http.response.validation_errors"), obj)
+		c.addInt("http.response.validation_error_count", 1)
+	}
+}
+
+// severities are the severities accepted by SetErrorWithSeverity, from least to most severe.
+var severities = []string{"info", "warning", "error", "critical"}
+
+// SetErrorWithSeverity records err in <key>.message along with its severity in <key>.severity and increments
+// log.error_count.  Severity must be one of "info", "warning", "error" or "critical"; any other value is recorded as
+// "error" and the mistake is recorded in clog.error.  A nil err is ignored.
+func SetErrorWithSeverity(ctx context.Context, key string, err error, severity string) {
+	if err == nil {
+		return
+	}
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if !slices.Contains(severities, severity) {
+			c.recordError(fmt.Errorf("SetErrorWithSeverity %s: unknown severity %q", key, severity))
+			severity = "error"
+		}
+		c.setString(key+".message", err.Error())
+		c.setString(key+".severity", severity)
+		c.addInt("log.error_count", 1)
+	}
+}
+
+// results are the values accepted by SetResult.
+var results = []string{"ok", "error", "cancelled", "timeout"}
+
+// SetResult records the outcome of the operation in result as one of "ok", "error", "cancelled" or "timeout", so that
+// HTTP and non-HTTP events can be compared on the same field.  Any other value is ignored and the mistake is recorded
+// in clog.error.  The CanonicalLogger middleware derives the result from the response unless it was already set.
+func SetResult(ctx context.Context, result string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if !slices.Contains(results, result) {
+			c.recordError(fmt.Errorf("SetResult: unknown result %q", result))
+			return
+		}
+		c.setString("result", result)
+	}
+}
+
+// TallyBool counts how often a flag was true or false.  The counts are stored in <key>.true and <key>.false.
+func TallyBool(ctx context.Context, key string, b bool) {
+	if b {
+		AddInt(ctx, key+".true", 1)
+	} else {
+		AddInt(ctx, key+".false", 1)
+	}
+}
+
+// SetRouteDecision records the backend a gateway routed the request to in routing.backend and why in routing.reason,
+// such as "canary" or "header_match".  An empty reason is not recorded.
+func SetRouteDecision(ctx context.Context, backend, reason string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setString("routing.backend", backend)
+		if reason != "" {
+			c.setString("routing.reason", reason)
+		}
+	}
+}
+
+// RecordCache records a lookup in the cache called name.  Hits and misses are counted in cache.<name>.hits and
+// cache.<name>.misses and the fraction of lookups that hit is kept up to date in cache.<name>.hit_ratio.
+func RecordCache(ctx context.Context, name string, hit bool) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		parts := c.normalizeKey("cache." + name)
+		at := func(field string) []string {
+			return append(parts[:len(parts):len(parts)], field)
+		}
+		if hit {
+			c.add(at("hits"), 1)
+		} else {
+			c.add(at("misses"), 1)
+		}
+		hits, _ := c.get(at("hits"))
+		misses, _ := c.get(at("misses"))
+		h, _ := hits.(int)
+		m, _ := misses.(int)
+		if h+m > 0 {
+			c.put(at("hit_ratio"), float64(h)/float64(h+m))
+		}
+	}
+}
+
+// IncAttempt counts an attempt at the operation called key, such as an outbound call made with retries, in
+// <key>.attempts.
+func IncAttempt(ctx context.Context, key string) {
+	AddInt(ctx, key+".attempts", 1)
+}
+
+// RecordRetry counts a retry of the operation called key in <key>.retries and appends reason to the array at
+// <key>.retry_reasons.
+func RecordRetry(ctx context.Context, key, reason string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.addInt(key+".retries", 1)
+		c.append(c.normalizeKey(key+".retry_reasons"), reason)
+	}
+}
+
+// OrBool accumulates b into the bool at key with a logical OR, so the key is true if any call passed true.  The first
+// call stores b.  This records flags such as whether any lookup in a loop missed the cache.
+func OrBool(ctx context.Context, key string, b bool) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), b, func(old any) bool {
+			o, ok := old.(bool)
+			return !ok || (b && !o)
+		})
+	}
+}
+
+// AndBool accumulates b into the bool at key with a logical AND, so the key is true only if every call passed true.
+// The first call stores b.
+func AndBool(ctx context.Context, key string, b bool) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey(key), b, func(old any) bool {
+			o, ok := old.(bool)
+			return !ok || (!b && o)
+		})
+	}
+}
+
+// Go runs fn in a new goroutine.  If fn panics, the panic is recovered and recorded in error.goroutine_panic instead
+// of crashing the process, since panics in goroutines spawned by a handler are not caught by the handler's caller.  A
+// panic recovered after the context was sealed is counted in clog.write_after_seal_count, even with WithStrictSeal.
+func Go(ctx context.Context, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				recordPanic(ctx, r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// recordPanic records a panic recovered by Go.  It never panics itself, since nothing above it would recover.
+func recordPanic(ctx context.Context, r any) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.sealed {
+			c.countWriteAfterSeal()
+			return
+		}
+		c.setString("error.goroutine_panic", fmt.Sprint(r))
+	}
+}
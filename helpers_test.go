@@ -0,0 +1,154 @@
+package clog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddValidationError(t *testing.T) {
+	ctx := Init(context.Background())
+	AddValidationError(ctx, "email", "must be a valid email address")
+	AddValidationError(ctx, "age", "must be at least 18")
+	AddValidationError(ctx, "name", "is required")
+
+	require.Equal(t, `{"http":{"response":{"validation_errors":[{"field":"email","message":"must be a valid email address"},{"field":"age","message":"must be at least 18"},{"field":"name","message":"is required"}],"validation_error_count":3}}}`, MarshalJSON(ctx))
+}
+
+func TestTallyBool(t *testing.T) {
+	ctx := Init(context.Background())
+	for _, hit := range []bool{true, false, true, true} {
+		TallyBool(ctx, "cache.hit", hit)
+	}
+
+	require.Equal(t, `{"cache":{"hit":{"true":3,"false":1}}}`, MarshalJSON(ctx))
+}
+
+func TestAppendObject(t *testing.T) {
+	ctx := Init(context.Background())
+	AppendObject(ctx, "db.queries", "table", "users", "rows", 3, "duration_ms", 1.5)
+	AppendObject(ctx, "db.queries", "Table", "orders", "rows", 0, "cached", true)
+	AppendObject(ctx, "db.queries", 42, "answer", "dangling")
+
+	expected := `{"db":{"queries":[{"table":"users","rows":3,"duration_ms":1.5},{"table":"orders","rows":0,"cached":true},{"42":"answer"}]}}`
+	for i := 0; i < 10; i++ {
+		require.Equal(t, expected, MarshalJSON(ctx))
+	}
+
+}
+
+func TestAppendObject_KeyOrder(t *testing.T) {
+	ctx := Init(context.Background())
+	AppendObject(ctx, "items", "z", 1, "a", 2, "m", 3)
+
+	require.Equal(t, `{"items":[{"z":1,"a":2,"m":3}]}`, MarshalJSON(ctx))
+}
+
+func TestGo(t *testing.T) {
+	ctx := Init(context.Background())
+	done := make(chan struct{})
+	Go(ctx, func() {
+		defer close(done)
+		SetString(ctx, "job.status", "started")
+	})
+	<-done
+
+	panicked := make(chan struct{})
+	Go(ctx, func() {
+		defer close(panicked)
+		panic("boom")
+	})
+	<-panicked
+
+	require.Eventually(t, func() bool {
+		return MarshalJSON(ctx) == `{"job":{"status":"started"},"error":{"goroutine_panic":"boom"}}`
+	}, time.Second, time.Millisecond)
+}
+
+func TestGo_StrictSeal(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithStrictSeal())
+	Seal(ctx)
+	Go(ctx, func() {
+		SetString(ctx, "job.status", "late")
+	})
+
+	require.Eventually(t, func() bool {
+		return MarshalJSON(ctx) == `{"clog":{"write_after_seal_count":1}}`
+	}, time.Second, time.Millisecond)
+}
+
+func TestSetErrorWithSeverity(t *testing.T) {
+	ctx := Init(context.Background())
+	SetErrorWithSeverity(ctx, "cache.error", errors.New("cache miss storm"), "warning")
+	SetErrorWithSeverity(ctx, "db.error", errors.New("connection refused"), "critical")
+	SetErrorWithSeverity(ctx, "queue.error", nil, "critical")
+	require.Equal(t, `{"cache":{"error":{"message":"cache miss storm","severity":"warning"}},"log":{"error_count":2},"db":{"error":{"message":"connection refused","severity":"critical"}}}`, MarshalJSON(ctx))
+}
+
+func TestSetErrorWithSeverity_Unknown(t *testing.T) {
+	ctx := Init(context.Background())
+	SetErrorWithSeverity(ctx, "db.error", errors.New("timeout"), "fatal")
+	require.Equal(t, `{"clog":{"error":"SetErrorWithSeverity db.error: unknown severity \"fatal\""},"db":{"error":{"message":"timeout","severity":"error"}},"log":{"error_count":1}}`, MarshalJSON(ctx))
+}
+
+func TestOrBool(t *testing.T) {
+	ctx := Init(context.Background())
+	OrBool(ctx, "cache.any_miss", false)
+	require.Equal(t, `{"cache":{"any_miss":false}}`, MarshalJSON(ctx))
+	OrBool(ctx, "cache.any_miss", true)
+	OrBool(ctx, "cache.any_miss", false)
+	require.Equal(t, `{"cache":{"any_miss":true}}`, MarshalJSON(ctx))
+}
+
+func TestAndBool(t *testing.T) {
+	ctx := Init(context.Background())
+	AndBool(ctx, "cache.all_hit", true)
+	require.Equal(t, `{"cache":{"all_hit":true}}`, MarshalJSON(ctx))
+	AndBool(ctx, "cache.all_hit", false)
+	AndBool(ctx, "cache.all_hit", true)
+	require.Equal(t, `{"cache":{"all_hit":false}}`, MarshalJSON(ctx))
+}
+
+func TestSetRouteDecision(t *testing.T) {
+	ctx := Init(context.Background())
+	SetRouteDecision(ctx, "orders-v2", "canary")
+	require.Equal(t, `{"routing":{"backend":"orders-v2","reason":"canary"}}`, MarshalJSON(ctx))
+
+	ctx = Init(context.Background())
+	SetRouteDecision(ctx, "orders-v1", "")
+	require.Equal(t, `{"routing":{"backend":"orders-v1"}}`, MarshalJSON(ctx))
+}
+
+func TestRecordCache(t *testing.T) {
+	ctx := Init(context.Background())
+	RecordCache(ctx, "users", false)
+	require.Equal(t, `{"cache":{"users":{"misses":1,"hit_ratio":0}}}`, MarshalJSON(ctx))
+
+	RecordCache(ctx, "users", true)
+	RecordCache(ctx, "users", true)
+	RecordCache(ctx, "users", true)
+	RecordCache(ctx, "sessions", true)
+	require.Equal(t, `{"cache":{"users":{"misses":1,"hit_ratio":0.75,"hits":3},"sessions":{"hits":1,"hit_ratio":1}}}`, MarshalJSON(ctx))
+}
+
+func TestSetResult(t *testing.T) {
+	ctx := Init(context.Background())
+	SetResult(ctx, "timeout")
+	require.Equal(t, `{"result":"timeout"}`, MarshalJSON(ctx))
+
+	SetResult(ctx, "failed")
+	require.Equal(t, `{"result":"timeout","clog":{"error":"SetResult: unknown result \"failed\""}}`, MarshalJSON(ctx))
+}
+
+func TestRecordRetry(t *testing.T) {
+	ctx := Init(context.Background())
+	IncAttempt(ctx, "payments")
+	RecordRetry(ctx, "payments", "timeout")
+	IncAttempt(ctx, "payments")
+	RecordRetry(ctx, "payments", "503")
+	IncAttempt(ctx, "payments")
+	require.Equal(t, `{"payments":{"attempts":3,"retries":2,"retry_reasons":["timeout","503"]}}`, MarshalJSON(ctx))
+}
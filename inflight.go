@@ -0,0 +1,71 @@
+package clog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// inflight holds the canonical logging contexts registered with Track, keyed by ID.
+var inflight = struct {
+	mu     sync.Mutex
+	events map[string]*canonical
+}{events: map[string]*canonical{}}
+
+// Track registers the canonical logging context in ctx under id so that InflightHandler includes it until untrack is
+// called.  Registering another context under the same id replaces the earlier one.  Uninitialized contexts are not
+// tracked.
+func Track(ctx context.Context, id string) (untrack func()) {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return func() {}
+	}
+
+	inflight.mu.Lock()
+	inflight.events[id] = c
+	inflight.mu.Unlock()
+
+	return func() {
+		inflight.mu.Lock()
+		defer inflight.mu.Unlock()
+		if inflight.events[id] == c {
+			delete(inflight.events, id)
+		}
+	}
+}
+
+// WithInflightTracking configures the middleware to Track each request while it is being served.  Requests are
+// tracked under their X-Request-ID header, or under a random ID if the header is missing.
+func WithInflightTracking() Option {
+	return func(o *options) {
+		o.inflightTracking = true
+	}
+}
+
+// InflightHandler returns a handler that serves the current events of all tracked contexts as a JSON object keyed by
+// ID.  Events are snapshots, so they show the values recorded so far.  It is intended for live debugging and should not
+// be exposed publicly since events may contain sensitive data.
+func InflightHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight.mu.Lock()
+		tracked := make(map[string]*canonical, len(inflight.events))
+		for id, c := range inflight.events {
+			tracked[id] = c
+		}
+		inflight.mu.Unlock()
+
+		events := make(map[string]json.RawMessage, len(tracked))
+		for id, c := range tracked {
+			c.mu.Lock()
+			b, err := c.marshal()
+			c.mu.Unlock()
+			if err == nil {
+				events[id] = b
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(events)
+	})
+}
@@ -0,0 +1,46 @@
+package clog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fetchInflight(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	InflightHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/clog/inflight", nil))
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	return w.Body.String()
+}
+
+func TestInflightHandler(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.path", "/orders")
+	untrack := Track(ctx, "req-1")
+	Track(context.Background(), "req-2")
+
+	require.JSONEq(t, `{"req-1":{"http":{"request":{"path":"/orders"}}}}`, fetchInflight(t))
+
+	untrack()
+	require.JSONEq(t, `{}`, fetchInflight(t))
+}
+
+func TestCanonicalLogger_ServeHTTP_InflightTracking(t *testing.T) {
+	var during string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "42")
+		during = fetchInflight(t)
+	})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "abc")
+	ServeAndCapture(handler, req, WithInflightTracking())
+
+	require.JSONEq(t, `{"abc":{"http":{"version":"1.1","request":{"method":"GET","path":"/test"}},"user":{"id":"42"}}}`, during)
+	require.JSONEq(t, `{}`, fetchInflight(t))
+}
@@ -1,8 +1,24 @@
 package clog
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,46 +26,811 @@ import (
 type CanonicalLogger struct {
 	wrapped http.Handler
 	logFn   func(string)
+	opts    *options
 }
 
-func NewCanonicalLogger(wrapped http.Handler, logFn func(string)) http.Handler {
+// NewCanonicalLogger returns a middleware that initializes a canonical logging context for each request and passes the
+// marshaled event to logFn once the wrapped handler returns.  Options customize which fields are recorded.  If the
+// request context is already initialized, such as by an outer CanonicalLogger, the fields are recorded into that
+// event and it is left to its creator to emit; logFn is not called.
+func NewCanonicalLogger(wrapped http.Handler, logFn func(string), opts ...Option) http.Handler {
 	if logFn == nil {
 		panic("logFn cannot be nil")
 	}
 	if wrapped == nil {
 		panic("wrapped cannot be nil")
 	}
-	return &CanonicalLogger{wrapped: wrapped, logFn: logFn}
+	return &CanonicalLogger{wrapped: wrapped, logFn: logFn, opts: newOptions(opts)}
+}
+
+// WithCapturedRequestHeaders configures the middleware to copy the named request headers into
+// http.request.headers.<name> before the handler runs.  Headers with multiple values are recorded as arrays.
+func WithCapturedRequestHeaders(names ...string) Option {
+	return func(o *options) {
+		o.capturedRequestHeaders = append(o.capturedRequestHeaders, names...)
+	}
+}
+
+// WithCapturedResponseHeaders configures the middleware to copy the named response headers into
+// http.response.headers.<name> after the handler runs.  Headers with multiple values are recorded as arrays.
+func WithCapturedResponseHeaders(names ...string) Option {
+	return func(o *options) {
+		o.capturedResponseHeaders = append(o.capturedResponseHeaders, names...)
+	}
+}
+
+// WithSelfSize configures the middleware to record the serialized size of the event in clog.event_bytes.  The size is
+// measured before the field itself is added, so the emitted event is a few bytes larger than the reported value.
+func WithSelfSize() Option {
+	return func(o *options) {
+		o.selfSize = true
+	}
+}
+
+// WithRuntimeStats configures the middleware to record a snapshot of Go runtime statistics when the event is emitted:
+// the bytes of allocated heap objects in runtime.alloc_bytes, the number of goroutines in runtime.num_goroutine and the
+// number of completed GC cycles in runtime.num_gc.  Reading memory statistics briefly stops the world, so this is best
+// reserved for low-volume or heavy handlers.
+func WithRuntimeStats() Option {
+	return func(o *options) {
+		o.runtimeStats = true
+	}
+}
+
+// WithKeyCountField configures the middleware to record the number of values in the emitted event in clog.key_count,
+// not counting the field itself, so that growth in event size can be tracked and alerted on.
+func WithKeyCountField() Option {
+	return func(o *options) {
+		o.keyCount = true
+	}
+}
+
+// WithWorkerIDFunc configures the middleware to record the identity of the worker serving the request in
+// runtime.worker_id using fn.
+func WithWorkerIDFunc(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.workerID = fn
+	}
+}
+
+// WithStatusClass configures the middleware to record the class of the response status code, such as "2xx" or "5xx",
+// in http.response.status_class.
+func WithStatusClass() Option {
+	return func(o *options) {
+		o.statusClass = true
+	}
+}
+
+// WithErrorCategory configures the middleware to record whether the response is an error in http.response.error and,
+// for status codes of 400 and above, the kind of error in error.category as "client_error" or "server_error".
+func WithErrorCategory() Option {
+	return func(o *options) {
+		o.errorCategory = true
+	}
+}
+
+// WithTLSInfo configures the middleware to record the negotiated TLS version, cipher suite and server name (SNI) in
+// tls.version, tls.cipher_suite and tls.server_name.  Nothing is recorded for requests not served over TLS.
+func WithTLSInfo() Option {
+	return func(o *options) {
+		o.tlsInfo = true
+	}
+}
+
+// WithClientCertInfo configures the middleware to record the subject, serial number and expiry of a verified TLS
+// client certificate in tls.client.subject, tls.client.serial and tls.client.not_after.  Nothing is recorded unless the
+// client presented a certificate that the server verified.
+func WithClientCertInfo() Option {
+	return func(o *options) {
+		o.clientCertInfo = true
+	}
+}
+
+// WithServerAddr configures the middleware to record the address and port of the listener that accepted the request in
+// http.server.address and http.server.port.  Nothing is recorded when the local address is unavailable, such as for
+// requests that were not received by an http.Server.
+func WithServerAddr() Option {
+	return func(o *options) {
+		o.serverAddr = true
+	}
+}
+
+// WithTraceContext configures the middleware to record the W3C Trace Context carried in the traceparent request
+// header.  The trace ID is recorded in trace.id and the sampled flag in trace.sampled.  Nothing is recorded when the
+// header is missing or malformed.  The header is read instead of a span in the request context so that clog does not
+// depend on a tracing SDK; it is the same header OpenTelemetry propagators extract the span context from, so the values
+// match the span of a tracing middleware that runs before this one.
+func WithTraceContext() Option {
+	return func(o *options) {
+		o.traceContext = true
+	}
+}
+
+// WithCaptureBodies configures the middleware to record up to maxBytes of the request and response bodies in
+// http.request.body and http.response.body.  The request body is restored so the handler still reads it in full.
+// Bodies often contain sensitive data; combine this with WithRedactKeys or enable it only for specific endpoints.
+func WithCaptureBodies(maxBytes int) Option {
+	return func(o *options) {
+		o.captureBodyBytes = maxBytes
+	}
+}
+
+// WithPeriodicFlush configures the middleware to emit a snapshot of the event every interval while the handler runs.
+// This is useful for long-lived requests such as websockets or server-sent events.  Snapshots are marked with
+// phase "periodic" and the event emitted when the handler returns is marked with phase "final".
+func WithPeriodicFlush(interval time.Duration) Option {
+	return func(o *options) {
+		o.flushInterval = interval
+	}
+}
+
+// MarshalErrorPolicy controls what the middleware emits when the event cannot be marshaled, for example because it
+// holds a NaN float.
+type MarshalErrorPolicy int
+
+const (
+	// MarshalErrorFallback emits a minimal event, {"clog":{"error":"marshal_failed"}}, so that the request is still
+	// counted.  This is the default.
+	MarshalErrorFallback MarshalErrorPolicy = iota
+	// MarshalErrorSkip emits nothing.
+	MarshalErrorSkip
+)
+
+// marshalFailedEvent is emitted in place of an event that cannot be marshaled under MarshalErrorFallback.
+const marshalFailedEvent = `{"clog":{"error":"marshal_failed"}}`
+
+// WithMarshalErrorPolicy sets what the middleware and Flush emit when the event cannot be marshaled.
+func WithMarshalErrorPolicy(policy MarshalErrorPolicy) Option {
+	return func(o *options) {
+		o.marshalErrorPolicy = policy
+	}
+}
+
+// WithOnMarshalError configures the middleware and Flush to call fn with the error when an event cannot be marshaled,
+// so the failure can be logged or counted.  Failed periodic snapshots are reported too but never replaced by a fallback.
+func WithOnMarshalError(fn func(error)) Option {
+	return func(o *options) {
+		o.onMarshalError = fn
+	}
+}
+
+// WithRouteGroupFunc configures the middleware to record the route group of the request, such as "v1" or "admin", in
+// http.route.group using fn.  Nothing is recorded if fn returns an empty string.
+func WithRouteGroupFunc(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.routeGroup = fn
+	}
+}
+
+// WithContextFieldMappings configures the middleware to copy values stored in the request context into the event.  Each
+// entry maps a context key to the event key its value is recorded under, for example {tenantKey{}: "tenant.id"}.
+// Strings, bools, integers and floats are recorded as-is and fmt.Stringer values by their String method.  Missing
+// values and values of other types are skipped.
+func WithContextFieldMappings(mappings map[any]string) Option {
+	return func(o *options) {
+		if o.contextFields == nil {
+			o.contextFields = make(map[any]string, len(mappings))
+		}
+		for key, field := range mappings {
+			o.contextFields[key] = field
+		}
+	}
+}
+
+// captureContextFields records the values mapped by WithContextFieldMappings.  Fields are set in sorted order so the
+// event is stable.
+func captureContextFields(ctx context.Context, mappings map[any]string) {
+	type mapping struct {
+		key   any
+		field string
+	}
+	sorted := make([]mapping, 0, len(mappings))
+	for key, field := range mappings {
+		sorted = append(sorted, mapping{key: key, field: field})
+	}
+	slices.SortFunc(sorted, func(a, b mapping) int { return strings.Compare(a.field, b.field) })
+
+	for _, m := range sorted {
+		switch v := ctx.Value(m.key).(type) {
+		case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			setValue(ctx, m.field, v)
+		case fmt.Stringer:
+			SetString(ctx, m.field, v.String())
+		}
+	}
+}
+
+// sequence is the process-wide counter used by WithSequence.
+var sequence atomic.Uint64
+
+// WithSequence configures the middleware to record a process-wide, monotonically increasing sequence number in clog.seq
+// for each emitted event.  This orders events from the same process when their timestamps collide.
+func WithSequence() Option {
+	return func(o *options) {
+		o.sequence = true
+	}
+}
+
+// WithDisabledFields configures the middleware to skip the listed default fields, such as http.request.body_bytes or
+// http.version.  Unlike filtering the event when it is marshaled, the values are never computed.  Paths must match
+// the default field exactly; fields set by handlers, such as with AddResponseBytes, are not affected.
+func WithDisabledFields(paths ...string) Option {
+	return func(o *options) {
+		if o.disabledFields == nil {
+			o.disabledFields = make(map[string]bool, len(paths))
+		}
+		for _, path := range paths {
+			o.disabledFields[path] = true
+		}
+	}
+}
+
+// WithTimestamps configures the middleware to record when the request started in http.request.start_time and when the
+// response finished in http.response.end_time.  Both are RFC 3339 timestamps in UTC taken from the clock set with
+// WithClock.
+func WithTimestamps() Option {
+	return func(o *options) {
+		o.timestamps = true
+	}
+}
+
+// WithEventID configures the middleware to record a random UUID in clog.event_id for each emitted event.  Unlike a
+// request ID it identifies the event itself, so collectors can drop duplicates caused by retried deliveries.
+func WithEventID() Option {
+	return func(o *options) {
+		o.eventID = true
+	}
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// WithBaggage configures the middleware to copy the listed members of the W3C baggage header into baggage.<member>.
+// Members that are not listed are ignored so that arbitrary client-supplied data does not end up in the event.
+func WithBaggage(members ...string) Option {
+	return func(o *options) {
+		o.baggageMembers = append(o.baggageMembers, members...)
+	}
 }
 
 func (cl *CanonicalLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	r = r.WithContext(Init(r.Context()))
-	SetString(r.Context(), "http.request.method", r.Method)
-	SetString(r.Context(), "http.request.path", r.URL.Path)
+	// A logger nested in another, or serving a context the caller already initialized, records its fields into the
+	// existing event and leaves sampling, emitting and sealing it to whoever created it.
+	owner := r.Context().Value(contextKey) == nil
+	if owner && !cl.opts.sampled() {
+		cl.wrapped.ServeHTTP(w, r.WithContext(Disabled(r.Context())))
+		return
+	}
 
-	start := time.Now()
-	resp := &loggingResponseWriter{ResponseWriter: w}
+	r = r.WithContext(initWithOptions(r.Context(), cl.opts))
+	if cl.enabled("http.request.method") {
+		SetString(r.Context(), "http.request.method", r.Method)
+	}
+	if cl.enabled("http.request.path") {
+		SetString(r.Context(), "http.request.path", r.URL.Path)
+	}
+	if cl.enabled("http.version") {
+		SetString(r.Context(), "http.version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor))
+	}
+	if cl.enabled("http.request.content_type") || cl.enabled("http.request.charset") {
+		mediaType, charset := parseContentType(r.Header.Get("Content-Type"))
+		if mediaType != "" && cl.enabled("http.request.content_type") {
+			SetString(r.Context(), "http.request.content_type", mediaType)
+		}
+		if charset != "" && cl.enabled("http.request.charset") {
+			SetString(r.Context(), "http.request.charset", charset)
+		}
+	}
+	if cl.opts.workerID != nil {
+		SetString(r.Context(), "runtime.worker_id", cl.opts.workerID(r))
+	}
+	if cl.opts.routeGroup != nil {
+		if group := cl.opts.routeGroup(r); group != "" {
+			SetString(r.Context(), "http.route.group", group)
+		}
+	}
+	if len(cl.opts.contextFields) > 0 {
+		captureContextFields(r.Context(), cl.opts.contextFields)
+	}
+	if len(cl.opts.baggageMembers) > 0 {
+		captureBaggage(r.Context(), r.Header.Values("Baggage"), cl.opts.baggageMembers)
+	}
+	if r.TLS != nil && cl.opts.tlsInfo {
+		SetString(r.Context(), "tls.version", tls.VersionName(r.TLS.Version))
+		SetString(r.Context(), "tls.cipher_suite", tls.CipherSuiteName(r.TLS.CipherSuite))
+		if r.TLS.ServerName != "" {
+			SetString(r.Context(), "tls.server_name", r.TLS.ServerName)
+		}
+	}
+	if r.TLS != nil && cl.opts.clientCertInfo && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		SetString(r.Context(), "tls.client.subject", cert.Subject.String())
+		SetString(r.Context(), "tls.client.serial", cert.SerialNumber.String())
+		SetString(r.Context(), "tls.client.not_after", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if cl.opts.traceContext {
+		captureTraceContext(r.Context(), r.Header.Get("traceparent"))
+	}
+	if cl.opts.serverAddr {
+		captureServerAddr(r.Context(), r.Context().Value(http.LocalAddrContextKey))
+	}
+	if ua := r.UserAgent(); ua != "" && cl.opts.parseUserAgent != nil {
+		parsed := cl.opts.parseUserAgent(ua)
+		SetString(r.Context(), "http.request.user_agent.original", ua)
+		SetString(r.Context(), "http.request.user_agent.browser", parsed.Browser)
+		SetString(r.Context(), "http.request.user_agent.os", parsed.OS)
+		SetString(r.Context(), "http.request.user_agent.device", parsed.Device)
+	}
+
+	captureHeaders(r.Context(), "http.request.headers.", r.Header, cl.opts.capturedRequestHeaders)
+
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		if cl.opts.captureBodyBytes > 0 {
+			captured, err := io.ReadAll(io.LimitReader(r.Body, int64(cl.opts.captureBodyBytes)))
+			if len(captured) > 0 {
+				SetString(r.Context(), "http.request.body", string(captured))
+			}
+			r.Body = &replayReader{Reader: io.MultiReader(bytes.NewReader(captured), &errReader{err: err}, r.Body), Closer: r.Body}
+		}
+		body = &countingReader{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	start := cl.opts.now()
+	if cl.opts.timestamps {
+		SetString(r.Context(), "http.request.start_time", start.UTC().Format(time.RFC3339Nano))
+	}
+	resp := &loggingResponseWriter{ResponseWriter: w, now: cl.opts.now, captureLimit: cl.opts.captureBodyBytes}
+	untrack := func() {}
+	if cl.opts.inflightTracking {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newUUID()
+		}
+		untrack = Track(r.Context(), id)
+	}
+	stopFlush := func() {}
+	if owner {
+		stopFlush = cl.startPeriodicFlush(r.Context())
+	}
 	cl.wrapped.ServeHTTP(resp, r)
-	duration := time.Since(start)
+	stopFlush()
+	untrack()
+	end := cl.opts.now()
+	duration := end.Sub(start)
+
+	if cl.enabled("http.response.duration_ms") {
+		SetInt(r.Context(), "http.response.duration_ms", int(duration.Milliseconds()))
+	}
+	if !resp.firstByte.IsZero() && cl.enabled("http.response.ttfb_ms") {
+		SetInt(r.Context(), "http.response.ttfb_ms", int(resp.firstByte.Sub(start).Milliseconds()))
+	}
+	if cl.opts.timestamps {
+		SetString(r.Context(), "http.response.end_time", end.UTC().Format(time.RFC3339Nano))
+	}
+
+	// Prefer the number of bytes the handler actually read.  Content-Length is missing for chunked uploads, so it is
+	// only used when the body was never read.
+	if cl.enabled("http.request.body_bytes") {
+		requestSize, _ := strconv.Atoi(r.Header.Get("Content-Length"))
+		if body != nil && body.read {
+			requestSize = int(body.n)
+		}
+		SetInt(r.Context(), "http.request.body_bytes", requestSize)
+	}
+
+	// Content-Length is authoritative when the handler sets it.  Otherwise, such as for chunked responses, the bytes
+	// written through the middleware are added to those reported with AddResponseBytes.
+	if cl.enabled("http.response.body_bytes") {
+		if responseSize, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+			SetInt(r.Context(), "http.response.body_bytes", responseSize)
+		} else {
+			AddInt(r.Context(), "http.response.body_bytes", int(resp.written))
+		}
+	}
+	if cl.enabled("http.response.status_code") {
+		SetInt(r.Context(), "http.response.status_code", resp.statusCode)
+	}
+	if cl.enabled("result") {
+		setResultIfUnset(r.Context(), requestResult(r.Context(), resp.statusCode))
+	}
+	if class := statusClass(resp.statusCode); class != "" && cl.opts.statusClass {
+		SetString(r.Context(), "http.response.status_class", class)
+	}
+	if cl.opts.errorCategory {
+		setValue(r.Context(), "http.response.error", resp.statusCode >= 400)
+		switch {
+		case resp.statusCode >= 500:
+			SetString(r.Context(), "error.category", "server_error")
+		case resp.statusCode >= 400:
+			SetString(r.Context(), "error.category", "client_error")
+		}
+	}
+	// body_bytes prefers Content-Length, which is the compressed size when a compression middleware sets it.
+	// written_bytes counts the bytes passed through this middleware, which are uncompressed if compression happens
+	// outside of it and compressed if it happens inside.
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+		SetString(r.Context(), "http.response.encoding", encoding)
+		SetInt(r.Context(), "http.response.written_bytes", int(resp.written))
+	}
+	captureHeaders(r.Context(), "http.response.headers.", w.Header(), cl.opts.capturedResponseHeaders)
+	if len(resp.captured) > 0 {
+		SetString(r.Context(), "http.response.body", string(resp.captured))
+	}
+	if !owner {
+		return
+	}
+	if cl.opts.flushInterval > 0 {
+		SetString(r.Context(), "phase", "final")
+	}
+
+	cl.emit(r.Context())
+	Seal(r.Context())
+}
+
+// startPeriodicFlush emits a snapshot of the event marked with phase "periodic" every flush interval until the
+// returned function is called.  The returned function waits for the flushing goroutine to exit, so no snapshot is
+// emitted after it returns.
+func (cl *CanonicalLogger) startPeriodicFlush(ctx context.Context) (stop func()) {
+	c, ok := fromContext(ctx)
+	if !ok || cl.opts.flushInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cl.opts.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				snap := c.snapshot()
+				c.mu.Unlock()
+
+				snap.setString("phase", "periodic")
+				b, err := snap.marshal()
+				if err != nil {
+					if cl.opts.onMarshalError != nil {
+						cl.opts.onMarshalError(err)
+					}
+					continue
+				}
+				cl.logFn(string(b))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// emit marshals the event and passes it to logFn.  The event is swapped out of the context so that values set by
+// lingering goroutines after this point are not torn between the emitted event and the context.
+func (cl *CanonicalLogger) emit(ctx context.Context) {
+	if cl.opts.sequence {
+		setValue(ctx, "clog.seq", sequence.Add(1))
+	}
+	if cl.opts.eventID {
+		SetString(ctx, "clog.event_id", newUUID())
+	}
+	if cl.opts.runtimeStats {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		SetInt(ctx, "runtime.alloc_bytes", int(m.Alloc))
+		SetInt(ctx, "runtime.num_goroutine", runtime.NumGoroutine())
+		SetInt(ctx, "runtime.num_gc", int(m.NumGC))
+	}
+	if cl.opts.keyCount {
+		SetInt(ctx, "clog.key_count", countKeys(ctx))
+	}
+	if cl.opts.selfSize {
+		SetInt(ctx, "clog.event_bytes", len(MarshalJSON(ctx)))
+	}
+	swapAndEmit(ctx, cl.opts, cl.logFn)
+}
+
+// swapAndEmit swaps the event out of ctx and passes it to logFn.  If the event cannot be marshaled, opts decide
+// whether the fallback event is emitted in its place.
+func swapAndEmit(ctx context.Context, opts *options, logFn func(string)) {
+	b, err := swapAndMarshal(ctx)
+	if err != nil {
+		if opts.onMarshalError != nil {
+			opts.onMarshalError(err)
+		}
+		if opts.marshalErrorPolicy == MarshalErrorFallback {
+			logFn(marshalFailedEvent)
+		}
+		return
+	}
+	if len(b) > 0 {
+		logFn(string(b))
+	}
+}
+
+// AddResponseBytes adds n to http.response.body_bytes for response bytes written through paths the middleware cannot
+// count, such as a websocket connection taken over with http.Hijacker or http.ResponseController.Hijack.  The
+// middleware adds the bytes written through its ResponseWriter to the total, unless the handler sets a Content-Length
+// header, which then takes precedence.
+func AddResponseBytes(ctx context.Context, n int) {
+	AddInt(ctx, "http.response.body_bytes", n)
+}
+
+// SetHandlerName records the name of the handler serving the request in http.handler, for grouping events by handler
+// rather than by path.  See NamedHandler for wiring it per route.
+func SetHandlerName(ctx context.Context, name string) {
+	SetString(ctx, "http.handler", name)
+}
+
+// NamedHandler returns a handler that records name in http.handler before calling h.  Wrap each route when registering
+// it so the name is recorded regardless of the router:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("GET /orders/{id}", clog.NamedHandler("get_order", getOrder))
+//	mux.Handle("POST /orders", clog.NamedHandler("create_order", createOrder))
+//	http.ListenAndServe(":8080", clog.NewCanonicalLogger(mux, logFn))
+//
+// Routers such as chi or gorilla/mux accept the wrapped handler the same way.
+func NamedHandler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetHandlerName(r.Context(), name)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// countKeys returns the number of values in the event as it will be marshaled.
+func countKeys(ctx context.Context) int {
+	c, ok := fromContext(ctx)
+	if !ok {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	walk(c.render(), nil, func([]string, any) { n++ })
+	return n
+}
+
+// statusClass returns the class of an HTTP status code, such as "4xx".  It returns an empty string for codes outside
+// of the 1xx-5xx range.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return ""
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// captureBaggage records the allowed members of W3C baggage headers under baggage.<member>.  Member properties are
+// dropped and values are percent-decoded.  Malformed members are skipped.
+func captureBaggage(ctx context.Context, headers []string, allowed []string) {
+	for _, header := range headers {
+		for _, member := range strings.Split(header, ",") {
+			member, _, _ = strings.Cut(member, ";")
+			key, value, ok := strings.Cut(member, "=")
+			if !ok {
+				continue
+			}
+			key = strings.TrimSpace(key)
+			if !slices.Contains(allowed, key) {
+				continue
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(value))
+			if err != nil {
+				continue
+			}
+			setValuePath(ctx, value, "baggage", key)
+		}
+	}
+}
+
+// requestResult derives the SetResult value for a request from its context and response status code.  499 is the
+// status nginx records for requests the client abandoned.
+func requestResult(ctx context.Context, statusCode int) string {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled) || statusCode == 499:
+		return "cancelled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusGatewayTimeout:
+		return "timeout"
+	case statusCode >= 400:
+		return "error"
+	}
+	return "ok"
+}
+
+// setResultIfUnset records result unless the handler already called SetResult.
+func setResultIfUnset(ctx context.Context, result string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIf(c.normalizeKey("result"), result, func(any) bool { return false })
+	}
+}
+
+// parseContentType returns the media type of a Content-Type header and its lowercased charset parameter.  Malformed
+// headers return empty strings.
+func parseContentType(contentType string) (mediaType, charset string) {
+	if contentType == "" {
+		return "", ""
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		return "", ""
+	}
+	return mediaType, strings.ToLower(params["charset"])
+}
+
+// enabled reports whether the default field at path has not been disabled with WithDisabledFields.
+func (cl *CanonicalLogger) enabled(path string) bool {
+	return !cl.opts.disabledFields[path]
+}
 
-	SetInt(r.Context(), "http.response.duration_ms", int(duration.Milliseconds()))
+// captureTraceContext records the trace ID, parent span ID and sampled flag of a W3C traceparent header, formatted as
+// version-traceid-parentid-flags.  Headers from future versions may carry additional fields after the flags.
+func captureTraceContext(ctx context.Context, traceparent string) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isLowerHex(traceID, 32) || !isLowerHex(spanID, 16) || !isLowerHex(flags, 2) {
+		return
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	SetString(ctx, "trace.id", traceID)
+	setValue(ctx, "trace.sampled", f&1 == 1)
+}
 
-	requestSize, _ := strconv.Atoi(r.Header.Get("Content-Length"))
-	SetInt(r.Context(), "http.request.body_bytes", requestSize)
+// isLowerHex reports whether s is n lowercase hexadecimal digits.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
 
-	responseSize, _ := strconv.Atoi(w.Header().Get("Content-Length"))
-	SetInt(r.Context(), "http.response.body_bytes", responseSize)
-	SetInt(r.Context(), "http.response.status_code", resp.statusCode)
+// captureServerAddr records the host and port of addr, which is expected to be the net.Addr the server stored under
+// http.LocalAddrContextKey.
+func captureServerAddr(ctx context.Context, addr any) {
+	local, ok := addr.(net.Addr)
+	if !ok {
+		return
+	}
+	host, port, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return
+	}
+	SetString(ctx, "http.server.address", host)
+	if p, err := strconv.Atoi(port); err == nil {
+		SetInt(ctx, "http.server.port", p)
+	}
+}
 
-	cl.logFn(MarshalJSON(r.Context()))
+// captureHeaders records the named headers under prefix.  Single values are stored as strings and multiple values as
+// arrays.  Missing headers are skipped.
+func captureHeaders(ctx context.Context, prefix string, h http.Header, names []string) {
+	for _, name := range names {
+		values := h.Values(name)
+		switch len(values) {
+		case 0:
+			continue
+		case 1:
+			SetString(ctx, prefix+name, values[0])
+		default:
+			setValue(ctx, prefix+name, append([]string(nil), values...))
+		}
+	}
 }
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	now        func() time.Time
+	firstByte  time.Time
+	written    int64
+
+	captureLimit int
+	captured     []byte
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.markFirstByte()
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	lrw.markFirstByte()
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.written += int64(n)
+	if remaining := lrw.captureLimit - len(lrw.captured); remaining > 0 {
+		lrw.captured = append(lrw.captured, b[:min(n, remaining)]...)
+	}
+	return n, err
+}
+
+// Flush sends any buffered data to the client, so streaming handlers such as server-sent events work behind the
+// middleware.  It does nothing if the underlying ResponseWriter cannot flush.
+func (lrw *loggingResponseWriter) Flush() {
+	lrw.markFirstByte()
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+	_ = http.NewResponseController(lrw.ResponseWriter).Flush()
+}
+
+// Hijack lets the handler take over the connection, such as for websockets.  Bytes written to a hijacked connection
+// are not counted; report them with AddResponseBytes.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(lrw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// markFirstByte records the time the handler first wrote to the response.
+func (lrw *loggingResponseWriter) markFirstByte() {
+	if lrw.firstByte.IsZero() {
+		lrw.firstByte = lrw.now()
+	}
+}
+
+// replayReader replays the captured prefix of a request body followed by the rest of the body.
+type replayReader struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader returns err once the captured prefix of a body has been replayed, so read errors hit while capturing are
+// seen by the handler.  A nil err lets the reader fall through to the rest of the body.
+type errReader struct {
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	if er.err != nil {
+		return 0, er.err
+	}
+	return 0, io.EOF
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n    int64
+	read bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	cr.read = true
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
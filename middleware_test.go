@@ -1,9 +1,23 @@
 package clog
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -14,15 +28,10 @@ func TestCanonicalLogger_ServeHTTP(t *testing.T) {
 		w.Header().Set("Content-Length", "2")
 		_, _ = w.Write([]byte("OK"))
 	})
-	logFn := func(log string) {
-		require.JSONEq(t, `{"http":{"request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":2,"status_code":200}}}`, log)
-	}
-	logger := NewCanonicalLogger(handler, logFn)
-
 	req, err := http.NewRequest("GET", "/test", nil)
 	require.NoError(t, err)
-	w := httptest.NewRecorder()
-	logger.ServeHTTP(w, req)
+	event, w := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":2,"status_code":200}},"result":"ok"}`, event)
 	require.Equal(t, http.StatusOK, w.Code)
 }
 
@@ -44,14 +53,859 @@ func TestCanonicalLogger_ServeHTTP_InvalidContentLength(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
 	})
-	logFn := func(log string) {
-		require.JSONEq(t, `{"http":{"request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":200}}}`, log)
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, w := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":2,"status_code":200}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_ChunkedRequestBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader("hello world")))
+	require.NoError(t, err)
+	require.Empty(t, req.Header.Get("Content-Length"))
+	event, w := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"POST","path":"/upload","body_bytes":11},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_UnreadRequestBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("POST", "/upload", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Length", "11")
+	event, w := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"POST","path":"/upload","body_bytes":11},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_UserAgentParsing(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "curl/8.4.0")
+	event, w := ServeAndCapture(handler, req, WithUserAgentParsing(nil))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","user_agent":{"original":"curl/8.4.0","browser":"curl","os":"other","device":"other"},"body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_UserAgentCustomParser(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	parser := func(ua string) UserAgent {
+		return UserAgent{Browser: "custom", OS: "custom-os", Device: "kiosk"}
+	}
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "KioskApp/1.0")
+	event, w := ServeAndCapture(handler, req, WithUserAgentParsing(parser))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","user_agent":{"original":"KioskApp/1.0","browser":"custom","os":"custom-os","device":"kiosk"},"body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_CapturedResponseHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, w := ServeAndCapture(handler, req, WithCapturedResponseHeaders("X-Cache", "Vary", "ETag"))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200,"headers":{"x-cache":"HIT","vary":["Accept","Accept-Encoding"]}}},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_SelfSize(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithSelfSize())
+
+	var decoded struct {
+		Clog struct {
+			EventBytes int `json:"event_bytes"`
+		} `json:"clog"`
 	}
-	logger := NewCanonicalLogger(handler, logFn)
+	require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+	overhead := len(fmt.Sprintf(`,"clog":{"event_bytes":%d}`, decoded.Clog.EventBytes))
+	require.Equal(t, len(event)-overhead, decoded.Clog.EventBytes)
+}
 
+func TestCanonicalLogger_ServeHTTP_WorkerID(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	workerID := func(r *http.Request) string { return "worker-7" }
 	req, err := http.NewRequest("GET", "/test", nil)
 	require.NoError(t, err)
+	event, w := ServeAndCapture(handler, req, WithWorkerIDFunc(workerID))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"runtime":{"worker_id":"worker-7"},"result":"ok"}`, event)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCanonicalLogger_ServeHTTP_StatusClass(t *testing.T) {
+	tests := []struct {
+		code   int
+		class  string
+		result string
+	}{
+		{http.StatusSwitchingProtocols, "1xx", "ok"},
+		{http.StatusOK, "2xx", "ok"},
+		{http.StatusNoContent, "2xx", "ok"},
+		{http.StatusMovedPermanently, "3xx", "ok"},
+		{http.StatusNotFound, "4xx", "error"},
+		{http.StatusServiceUnavailable, "5xx", "error"},
+	}
+	for _, tt := range tests {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.code)
+		})
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, w := ServeAndCapture(handler, req, WithStatusClass())
+		require.JSONEq(t, fmt.Sprintf(`{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":%d,"status_class":%q}},"result":%q}`, tt.code, tt.class, tt.result), event)
+		require.Equal(t, tt.code, w.Code)
+	}
+}
+
+func TestStatusClass_OutOfRange(t *testing.T) {
+	require.Equal(t, "", statusClass(0))
+	require.Equal(t, "", statusClass(600))
+}
+
+func TestCanonicalLogger_ServeHTTP_TimeToFirstByte(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("OK"))
+		time.Sleep(20 * time.Millisecond)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req)
+
+	var decoded struct {
+		HTTP struct {
+			Response struct {
+				DurationMs int  `json:"duration_ms"`
+				TTFBMs     *int `json:"ttfb_ms"`
+				StatusCode int  `json:"status_code"`
+			} `json:"response"`
+		} `json:"http"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+	require.NotNil(t, decoded.HTTP.Response.TTFBMs)
+	require.GreaterOrEqual(t, *decoded.HTTP.Response.TTFBMs, 20)
+	require.GreaterOrEqual(t, decoded.HTTP.Response.DurationMs, *decoded.HTTP.Response.TTFBMs+20)
+	require.Equal(t, http.StatusOK, decoded.HTTP.Response.StatusCode)
+}
+
+func TestCanonicalLogger_ServeHTTP_NoWrite(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_SampleRate(t *testing.T) {
+	var handled int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		SetString(r.Context(), "foo", "bar")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var logged int
+	for _, rate := range []float64{0, 1} {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, w := ServeAndCapture(handler, req, WithSampleRate(rate))
+		if event != "" {
+			logged++
+		}
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	require.Equal(t, 2, handled)
+	require.Equal(t, 1, logged)
+}
+
+func TestCanonicalLogger_ServeHTTP_Flush(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			require.NoError(t, http.NewResponseController(w).Flush())
+			AddInt(r.Context(), "stream.messages", 1)
+		}
+		w.(http.Flusher).Flush()
+	})
+	req, err := http.NewRequest("GET", "/events", nil)
+	require.NoError(t, err)
+	event, w := ServeAndCapture(handler, req, WithPeriodicFlush(time.Hour))
+	require.True(t, w.Flushed)
+	require.Equal(t, "data: 0\n\ndata: 1\n\ndata: 2\n\n", w.Body.String())
+	require.Contains(t, event, `"stream":{"messages":3}`)
+	require.Contains(t, event, `"status_code":200`)
+}
+
+func TestCanonicalLogger_ServeHTTP_Nested(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "42")
+		w.WriteHeader(http.StatusCreated)
+	})
+	var inner, outer []string
+	logger := NewCanonicalLogger(
+		NewCanonicalLogger(handler, func(log string) { inner = append(inner, log) }, WithStatusClass()),
+		func(log string) { outer = append(outer, log) },
+		WithClock(func() time.Time { return time.Time{} }),
+	)
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	logger.ServeHTTP(httptest.NewRecorder(), req)
+	require.Empty(t, inner)
+	require.Len(t, outer, 1)
+	require.NotContains(t, outer[0], "write_after_seal_count")
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":201,"status_class":"2xx"}},"user":{"id":"42"},"result":"ok"}`, outer[0])
+}
+
+func TestCanonicalLogger_ServeHTTP_AlreadyInitialized(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "42")
+	})
+	ctx := Init(context.Background())
+	SetString(ctx, "job.id", "j-1")
+	req, err := http.NewRequestWithContext(ctx, "GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithSampleRate(0))
+	require.Empty(t, event)
+
+	SetString(ctx, "job.status", "done")
+	event = MarshalJSON(ctx)
+	require.NotContains(t, event, "write_after_seal_count")
+	require.Contains(t, event, `"job":{"id":"j-1","status":"done"}`)
+	require.Contains(t, event, `"user":{"id":"42"}`)
+	require.Contains(t, event, `"method":"GET"`)
+}
+
+func TestCanonicalLogger_ServeHTTP_PeriodicFlush(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "stream.id", "s-1")
+		for i := 0; i < 5; i++ {
+			AddInt(r.Context(), "stream.messages", 1)
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var mu sync.Mutex
+	var events []string
+	logFn := func(log string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, log)
+	}
+	logger := NewCanonicalLogger(handler, logFn, WithPeriodicFlush(15*time.Millisecond))
+
+	req, err := http.NewRequest("GET", "/stream", nil)
+	require.NoError(t, err)
 	w := httptest.NewRecorder()
 	logger.ServeHTTP(w, req)
-	require.Equal(t, http.StatusOK, w.Code)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(events), 2)
+	for _, event := range events[:len(events)-1] {
+		require.Contains(t, event, `"phase":"periodic"`)
+		require.Contains(t, event, `"stream":{"id":"s-1"`)
+	}
+	final := events[len(events)-1]
+	require.Contains(t, final, `"phase":"final"`)
+	require.Contains(t, final, `"stream":{"id":"s-1","messages":5}`)
+
+	// The flushing goroutine must stop once the handler returns.
+	count := len(events)
+	mu.Unlock()
+	time.Sleep(40 * time.Millisecond)
+	mu.Lock()
+	require.Equal(t, count, len(events))
+}
+
+func TestCanonicalLogger_ServeHTTP_RouteGroup(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	routeGroup := func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			return "v1"
+		}
+		return ""
+	}
+
+	req, err := http.NewRequest("GET", "/v1/users", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithRouteGroupFunc(routeGroup))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/v1/users","body_bytes":0},"route":{"group":"v1"},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+
+	req, err = http.NewRequest("GET", "/healthz", nil)
+	require.NoError(t, err)
+	event, _ = ServeAndCapture(handler, req, WithRouteGroupFunc(routeGroup))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/healthz","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_Sequence(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var seqs []uint64
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, _ := ServeAndCapture(handler, req, WithSequence())
+
+		var decoded struct {
+			Clog struct {
+				Seq uint64 `json:"seq"`
+			} `json:"clog"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+		seqs = append(seqs, decoded.Clog.Seq)
+	}
+
+	require.NotZero(t, seqs[0])
+	require.Greater(t, seqs[1], seqs[0])
+}
+
+func TestCanonicalLogger_ServeHTTP_Baggage(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Header.Set("Baggage", "tenant.id=acme%20corp;ttl=60, user.tier=gold, secret=shh")
+	event, _ := ServeAndCapture(handler, req, WithBaggage("tenant.id", "user.tier"))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"baggage":{"tenant.id":"acme corp","user.tier":"gold"},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_TLSInfo(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.TLS = &tls.ConnectionState{
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		ServerName:  "api.example.com",
+	}
+	event, _ := ServeAndCapture(handler, req, WithTLSInfo())
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"tls":{"version":"TLS 1.3","cipher_suite":"TLS_AES_128_GCM_SHA256","server_name":"api.example.com"},"result":"ok"}`, event)
+
+	req, err = http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ = ServeAndCapture(handler, req, WithTLSInfo())
+	require.NotContains(t, event, `"tls"`)
+}
+
+func TestCanonicalLogger_ServeHTTP_ContentEncoding(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ab"))
+		_, _ = w.Write([]byte("cd"))
+	})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":4,"status_code":200,"encoding":"gzip","written_bytes":4}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_CaptureBodies(t *testing.T) {
+	var received string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		received = string(b)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1,`))
+		_, _ = w.Write([]byte(`"name":"widget"}`))
+	})
+
+	req, err := http.NewRequest("POST", "/widgets", strings.NewReader(`{"name":"widget"}`))
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithCaptureBodies(12))
+	require.Equal(t, `{"name":"widget"}`, received)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"POST","path":"/widgets","body":"{\"name\":\"wid","body_bytes":17},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":24,"status_code":201,"body":"{\"id\":1,\"nam"}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_CaptureBodiesRedacted(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte(`{"token":"secret"}`))
+	})
+
+	req, err := http.NewRequest("POST", "/login", strings.NewReader(`{"password":"hunter2"}`))
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithCaptureBodies(1024), WithRedactKeys("http.request.body", "http.response.body"))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"POST","path":"/login","body":"[REDACTED]","body_bytes":22},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":18,"status_code":200,"body":"[REDACTED]"}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_ErrorCategory(t *testing.T) {
+	tests := []struct {
+		code     int
+		expected string
+	}{
+		{http.StatusOK, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200,"error":false}},"result":"ok"}`},
+		{http.StatusNotFound, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":404,"error":true}},"error":{"category":"client_error"},"result":"error"}`},
+		{http.StatusServiceUnavailable, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":503,"error":true}},"error":{"category":"server_error"},"result":"error"}`},
+	}
+	for _, tt := range tests {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.code)
+		})
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, _ := ServeAndCapture(handler, req, WithErrorCategory())
+		require.JSONEq(t, tt.expected, event)
+	}
+}
+
+func TestCanonicalLogger_ServeHTTP_Clock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(3 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		now = now.Add(4 * time.Millisecond)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithClock(func() time.Time { return now }))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":7,"ttfb_ms":3,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_Timestamps(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(1500 * time.Millisecond)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithTimestamps(), WithClock(func() time.Time { return now }))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","start_time":"2024-01-01T17:30:00Z","body_bytes":0},"response":{"duration_ms":1500,"end_time":"2024-01-01T17:30:01.5Z","body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_TraceContext(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		traceparent string
+		expected    string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", `,"trace":{"id":"4bf92f3577b34da6a3ce929d0e0e4736","sampled":true}`},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", `,"trace":{"id":"4bf92f3577b34da6a3ce929d0e0e4736","sampled":false}`},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra", `,"trace":{"id":"4bf92f3577b34da6a3ce929d0e0e4736","sampled":true}`},
+		{"", ``},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ``},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ``},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ``},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ``},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ``},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		if tt.traceparent != "" {
+			req.Header.Set("traceparent", tt.traceparent)
+		}
+		event, _ := ServeAndCapture(handler, req, WithTraceContext())
+		require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}}`+tt.expected+`,"result":"ok"}`, event, tt.traceparent)
+	}
+}
+
+func TestCanonicalLogger_ServeHTTP_DisabledFields(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello"))
+	})
+	req, err := http.NewRequest("POST", "/test", strings.NewReader("body"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	event, _ := ServeAndCapture(handler, req, WithClock(func() time.Time { return time.Time{} }),
+		WithDisabledFields("http.request.body_bytes", "http.response.body_bytes", "http.response.ttfb_ms", "http.request.charset", "http.version"))
+	require.JSONEq(t, `{"http":{"request":{"method":"POST","path":"/test","content_type":"text/plain"},"response":{"duration_ms":0,"status_code":200}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_ValueTransform(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "12345")
+	})
+	trimSlash := func(v any) any {
+		if s, ok := v.(string); ok && s != "/" {
+			return strings.TrimSuffix(s, "/")
+		}
+		return v
+	}
+	bucket := func(v any) any {
+		if s, ok := v.(string); ok {
+			return s[:1] + "xxx"
+		}
+		return v
+	}
+	req, err := http.NewRequest("GET", "/users/", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithClock(func() time.Time { return time.Time{} }),
+		WithValueTransform("http.request.path", trimSlash), WithValueTransform("User.ID", bucket))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/users","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"user":{"id":"1xxx"},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_Result(t *testing.T) {
+	tests := []struct {
+		handler  http.HandlerFunc
+		expected string
+	}{
+		{func(w http.ResponseWriter, r *http.Request) {}, "ok"},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }, "error"},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }, "error"},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusGatewayTimeout) }, "timeout"},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(499) }, "cancelled"},
+		{func(w http.ResponseWriter, r *http.Request) {
+			SetResult(r.Context(), "cancelled")
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "cancelled"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, _ := ServeAndCapture(tt.handler, req)
+		require.Contains(t, event, `"result":"`+tt.expected+`"`)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), req)
+	require.Contains(t, event, `"result":"cancelled"`)
+}
+
+func TestCanonicalLogger_ServeHTTP_GlobalProvider(t *testing.T) {
+	t.Cleanup(func() { providers = nil })
+	RegisterGlobalProvider(func(ctx context.Context) {
+		SetString(ctx, "build.sha", "abc123")
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), req)
+	require.Contains(t, event, `"build":{"sha":"abc123"}`)
+}
+
+func TestCanonicalLogger_ServeHTTP_ServerAddr(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8443}
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, addr))
+	event, _ := ServeAndCapture(handler, req, WithServerAddr())
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"server":{"address":"10.0.0.1","port":8443},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+
+	req, err = http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ = ServeAndCapture(handler, req, WithServerAddr())
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_RuntimeStats(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithRuntimeStats())
+
+	var decoded struct {
+		Runtime map[string]any `json:"runtime"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+	require.Len(t, decoded.Runtime, 3)
+	for _, key := range []string{"alloc_bytes", "num_goroutine", "num_gc"} {
+		require.IsType(t, float64(0), decoded.Runtime[key], key)
+	}
+	require.Greater(t, decoded.Runtime["alloc_bytes"], float64(0))
+	require.GreaterOrEqual(t, decoded.Runtime["num_goroutine"], float64(1))
+}
+
+func TestCanonicalLogger_ServeHTTP_ClientCertInfo(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		SerialNumber: big.NewInt(4242),
+		NotAfter:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	event, _ := ServeAndCapture(handler, req, WithClientCertInfo())
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"tls":{"client":{"subject":"CN=billing,O=Example","serial":"4242","not_after":"2030-01-02T03:04:05Z"}},"result":"ok"}`, event)
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	event, _ = ServeAndCapture(handler, req, WithClientCertInfo())
+	require.NotContains(t, event, `"tls"`)
+}
+
+func TestCanonicalLogger_ServeHTTP_HandlerName(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /orders/{id}", NamedHandler("get_order", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		SetHandlerName(r.Context(), "health")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "/orders/1", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(mux, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/orders/1","body_bytes":0},"handler":"get_order","response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+
+	req, err = http.NewRequest("GET", "/health", nil)
+	require.NoError(t, err)
+	event, _ = ServeAndCapture(mux, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/health","body_bytes":0},"handler":"health","response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":200}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_EventID(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, _ := ServeAndCapture(handler, req, WithEventID())
+
+		var decoded struct {
+			Clog struct {
+				EventID string `json:"event_id"`
+			} `json:"clog"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+		require.Regexp(t, uuid, decoded.Clog.EventID)
+		ids = append(ids, decoded.Clog.EventID)
+	}
+	require.NotEqual(t, ids[0], ids[1])
+}
+
+func TestCanonicalLogger_ServeHTTP_Version(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	event, _ := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"2.0","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_RedactUnlessError(t *testing.T) {
+	for _, tt := range []struct {
+		code     int
+		expected string
+		result   string
+	}{
+		{http.StatusOK, `"[REDACTED]"`, "ok"},
+		{http.StatusFound, `"[REDACTED]"`, "ok"},
+		{http.StatusBadRequest, `"alice@example.com"`, "error"},
+		{http.StatusInternalServerError, `"alice@example.com"`, "error"},
+	} {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetString(r.Context(), "user.email", "alice@example.com")
+			w.WriteHeader(tt.code)
+		})
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		event, _ := ServeAndCapture(handler, req, WithRedactUnlessError("user.email"))
+		require.JSONEq(t, fmt.Sprintf(`{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"ttfb_ms":0,"body_bytes":0,"status_code":%d}},"user":{"email":%s},"result":%q}`, tt.code, tt.expected, tt.result), event)
+	}
+}
+
+func TestCanonicalLogger_ServeHTTP_CapturedRequestHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Accept", "text/plain")
+	event, _ := ServeAndCapture(handler, req, WithCapturedRequestHeaders("X-Tenant", "Accept", "Authorization"))
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","headers":{"x-tenant":"acme","accept":["application/json","text/plain"]},"body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_HijackedResponseBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		n, _ := rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello")
+		require.NoError(t, rw.Flush())
+		AddResponseBytes(r.Context(), n)
+	})
+	events := make(chan string, 1)
+	srv := httptest.NewServer(NewCanonicalLogger(handler, func(log string) { events <- log }))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ws")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "hello", string(body))
+	require.Contains(t, <-events, `"body_bytes":62`)
+}
+
+func TestCanonicalLogger_ServeHTTP_StreamedResponseBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("chunk-1"))
+		_, _ = w.Write([]byte("chunk-2"))
+		AddResponseBytes(r.Context(), 100)
+		AddResponseBytes(r.Context(), 50)
+	})
+	req, err := http.NewRequest("GET", "/stream", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/stream","body_bytes":0},"response":{"body_bytes":164,"duration_ms":0,"ttfb_ms":0,"status_code":200}},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_MarshalError(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetFloat64(r.Context(), "model.score", math.NaN())
+	})
+
+	var errs []error
+	onError := WithOnMarshalError(func(err error) { errs = append(errs, err) })
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, onError)
+	require.Equal(t, `{"clog":{"error":"marshal_failed"}}`, event)
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "NaN")
+
+	event, _ = ServeAndCapture(handler, req, onError, WithMarshalErrorPolicy(MarshalErrorSkip))
+	require.Empty(t, event)
+	require.Len(t, errs, 2)
+}
+
+func TestCanonicalLogger_ServeHTTP_KeyCountField(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetString(r.Context(), "user.id", "42")
+		AppendObject(r.Context(), "db.queries", "table", "users")
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	event, _ := ServeAndCapture(handler, req, WithKeyCountField(), WithEventID())
+
+	var keys int
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+	var count func(m map[string]any)
+	count = func(m map[string]any) {
+		for _, v := range m {
+			if child, ok := v.(map[string]any); ok {
+				count(child)
+			} else {
+				keys++
+			}
+		}
+	}
+	count(decoded)
+	require.Equal(t, float64(keys-1), decoded["clog"].(map[string]any)["key_count"])
+	require.Equal(t, float64(12), decoded["clog"].(map[string]any)["key_count"])
+}
+
+type tenantKey struct{}
+
+type regionKey struct{}
+
+type unsupportedKey struct{}
+
+func TestCanonicalLogger_ServeHTTP_ContextFieldMappings(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mappings := WithContextFieldMappings(map[any]string{
+		tenantKey{}:      "tenant.id",
+		regionKey{}:      "tenant.region",
+		unsupportedKey{}: "tenant.meta",
+		"missing":        "tenant.missing",
+	})
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	ctx := context.WithValue(req.Context(), tenantKey{}, "acme")
+	ctx = context.WithValue(ctx, regionKey{}, net.ParseIP("10.0.0.1"))
+	ctx = context.WithValue(ctx, unsupportedKey{}, []string{"a"})
+	event, _ := ServeAndCapture(handler, req.WithContext(ctx), mappings)
+	require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test","body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"tenant":{"id":"acme","region":"10.0.0.1"},"result":"ok"}`, event)
+}
+
+func TestCanonicalLogger_ServeHTTP_DoubleWrapped(t *testing.T) {
+	warnings := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	inner := NewCanonicalLogger(handler, func(string) {}, WithInitWarnFunc(func() { warnings++ }))
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	ServeAndCapture(inner, req)
+	require.Equal(t, 1, warnings)
+}
+
+func TestCanonicalLogger_ServeHTTP_SealsAfterEmit(t *testing.T) {
+	var reqCtx context.Context
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx = r.Context()
+	})
+	req, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	ServeAndCapture(handler, req)
+
+	SetString(reqCtx, "late", "value")
+	require.Equal(t, `{"clog":{"write_after_seal_count":1}}`, MarshalJSON(reqCtx))
+}
+
+func TestCanonicalLogger_ServeHTTP_ContentType(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"application/json; charset=UTF-8", `"content_type":"application/json","charset":"utf-8",`},
+		{"text/plain", `"content_type":"text/plain",`},
+		{"text/html; charset", `"content_type":"text/html",`},
+		{"not a media type", ``},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", tt.header)
+		event, _ := ServeAndCapture(handler, req)
+		require.JSONEq(t, `{"http":{"version":"1.1","request":{"method":"GET","path":"/test",`+tt.expected+`"body_bytes":0},"response":{"duration_ms":0,"body_bytes":0,"status_code":0}},"result":"ok"}`, event, tt.header)
+	}
 }
@@ -0,0 +1,85 @@
+package clog
+
+import (
+	"context"
+	"slices"
+
+	"github.com/wk8/go-ordered-map/v2"
+)
+
+// namespace is a canonical registered with its parent by InitNamespace.
+type namespace struct {
+	name string
+	c    *canonical
+}
+
+// InitNamespace returns a context whose canonical logging context is a separate event tree named name within the one
+// in ctx.  All setters used with the returned context write to the namespace, so independent libraries can record
+// values without their keys colliding.  When the parent event is marshaled, each namespace is merged in under its name,
+// replacing any value the parent holds at that key.  Marshal-time options such as WithMaxKeys are applied once to the
+// merged event.  Redaction paths match both from the root of the event and from the root of each namespace, and
+// WithRedactUnlessError uses the parent's status code.  A dotted name such as "lib.cache" is split like a key, so the
+// namespace is merged in at {"lib":{"cache":...}}.  Calling InitNamespace again with the same name returns the
+// existing namespace.  If ctx is not initialized, it is returned unchanged.
+func InitNamespace(ctx context.Context, name string) context.Context {
+	parent, ok := fromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	for _, ns := range parent.namespaces {
+		if ns.name == name {
+			return context.WithValue(ctx, contextKey, ns.c)
+		}
+	}
+	c := newCanonical(parent.opts)
+	parent.namespaces = append(parent.namespaces, namespace{name: name, c: c})
+	return context.WithValue(ctx, contextKey, c)
+}
+
+// merged returns a copy of the values of c with its namespaces merged in under their names, along with the paths of
+// the namespaces within the copy.  The namespaces are merged as stored; marshal-time options are applied by the
+// caller to the merged tree.  Namespaces are locked while they are copied, so c's own lock must be held and namespaces
+// must never lock their parent.
+func (c *canonical) merged() (*orderedmap.OrderedMap[string, any], [][]string) { //nolint:typecheck
+	values := cloneMap(c.values)
+	var roots [][]string
+	for _, ns := range c.namespaces {
+		path := c.normalizeKey(ns.name)
+		ns.c.mu.Lock()
+		nsValues, nsRoots := ns.c.merged()
+		ns.c.mu.Unlock()
+		state := values
+		for _, part := range path[:len(path)-1] {
+			val, _ := state.Get(part)
+			next, ok := val.(*orderedmap.OrderedMap[string, any])
+			if !ok {
+				next = orderedmap.New[string, any]() //nolint:typecheck
+				state.Set(part, next)
+			}
+			state = next
+		}
+		state.Set(path[len(path)-1], nsValues)
+		roots = append(roots, path)
+		for _, root := range nsRoots {
+			roots = append(roots, append(slices.Clone(path), root...))
+		}
+	}
+	return values, roots
+}
+
+// swap replaces the values of c and its namespaces with empty trees and returns a detached canonical holding the
+// previous values, including those of the namespaces.  c must be locked.
+func (c *canonical) swap() *canonical {
+	old := c.detached(c.values)
+	c.values = orderedmap.New[string, any]() //nolint:typecheck
+	c.reservoirs = nil
+	for _, ns := range c.namespaces {
+		ns.c.mu.Lock()
+		old.namespaces = append(old.namespaces, namespace{name: ns.name, c: ns.c.swap()})
+		ns.c.mu.Unlock()
+	}
+	return old
+}
@@ -0,0 +1,99 @@
+package clog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitNamespace(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+
+	cache := InitNamespace(ctx, "cache")
+	db := InitNamespace(ctx, "db")
+	SetString(cache, "status", "hit")
+	AddInt(cache, "lookups", 1)
+	AddInt(db, "lookups", 3)
+	AddInt(InitNamespace(ctx, "cache"), "lookups", 1)
+	InitNamespace(ctx, "unused")
+
+	require.Equal(t, `{"status":"hit","lookups":2}`, MarshalJSON(cache))
+	require.Equal(t, `{"lookups":3}`, MarshalJSON(db))
+	require.Equal(t, `{"http":{"request":{"method":"GET"}},"cache":{"status":"hit","lookups":2},"db":{"lookups":3}}`, MarshalJSON(ctx))
+
+	require.Equal(t, `{"http":{"request":{"method":"GET"}},"cache":{"status":"hit","lookups":2},"db":{"lookups":3}}`, SwapAndMarshal(ctx))
+	require.Equal(t, `{}`, MarshalJSON(ctx))
+	AddInt(db, "lookups", 1)
+	require.Equal(t, `{"db":{"lookups":1}}`, MarshalJSON(ctx))
+}
+
+func TestInitNamespace_DottedName(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRedactKeys("token"))
+	SetString(ctx, "lib.version", "1.2")
+	SetString(InitNamespace(ctx, "lib.a"), "token", "secret")
+	AddInt(InitNamespace(ctx, "lib.b"), "calls", 1)
+
+	require.Equal(t, `{"lib":{"version":"1.2","a":{"token":"[REDACTED]"},"b":{"calls":1}}}`, MarshalJSON(ctx))
+}
+
+func TestInitNamespace_Uninitialized(t *testing.T) {
+	ctx := InitNamespace(context.Background(), "cache")
+	SetString(ctx, "status", "hit")
+	require.Equal(t, "", MarshalJSON(ctx))
+}
+
+func TestInitNamespace_RedactUnlessError(t *testing.T) {
+	for _, tt := range []struct {
+		code     int
+		expected string
+	}{
+		{200, `"[REDACTED]"`},
+		{500, `"alice@example.com"`},
+	} {
+		ctx := InitWithOptions(context.Background(), WithRedactUnlessError("user.email"), WithRedactKeys("password"))
+		SetInt(ctx, "http.response.status_code", tt.code)
+		SetString(ctx, "user.email", "alice@example.com")
+		lib := InitNamespace(ctx, "lib")
+		SetString(lib, "user.email", "alice@example.com")
+		SetString(lib, "password", "hunter2")
+
+		require.Equal(t, fmt.Sprintf(`{"http":{"response":{"status_code":%d}},"user":{"email":%s},"lib":{"user":{"email":%s},"password":"[REDACTED]"}}`, tt.code, tt.expected, tt.expected), MarshalJSON(ctx))
+	}
+}
+
+func TestInitNamespace_MaxKeys(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithMaxKeys(3))
+	SetInt(ctx, "a", 1)
+	SetInt(ctx, "b", 2)
+	lib := InitNamespace(ctx, "lib")
+	SetInt(lib, "c", 3)
+	SetInt(lib, "d", 4)
+	SetInt(lib, "e", 5)
+
+	require.Equal(t, `{"a":1,"b":2,"lib":{"c":3},"clog":{"dropped_keys":2}}`, MarshalJSON(ctx))
+}
+
+func TestInitNamespace_Inspect(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "http.request.method", "GET")
+	before := Init(context.Background())
+	SetString(before, "http.request.method", "GET")
+	lib := InitNamespace(InitNamespace(ctx, "lib"), "inner")
+	SetInt(lib, "count", 2)
+
+	var paths []string
+	Walk(ctx, func(path []string, _ any) {
+		paths = append(paths, strings.Join(path, "."))
+	})
+	require.Equal(t, []string{"http.request.method", "lib.inner.count"}, paths)
+
+	v, ok := Snapshot(ctx).Int("lib.inner.count")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	require.Equal(t, map[string]DiffEntry{"lib.inner.count": {New: 2}}, Diff(before, ctx))
+	require.Equal(t, map[string]float64{"lib.inner.count": 2}, NumericFields(ctx))
+}
@@ -0,0 +1,381 @@
+package clog
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// alias maps a canonical key path to the path it is written to when marshaled.
+type alias struct {
+	from []string
+	to   []string
+}
+
+// Option configures the canonical logging context or the CanonicalLogger middleware.
+type Option func(*options)
+
+type options struct {
+	maxDepth          int
+	maxValueBytes     int
+	maxEventBytes     int
+	maxKeys           int
+	sanitizeValues    bool
+	noHTMLEscape      bool
+	durationString    bool
+	sourceAnnotations bool
+	traceWriter       io.Writer
+	redactKeys        [][]string
+	redactUnlessError [][]string
+	aliases           []alias
+	writePolicy       WritePolicy
+	rollupTotals      bool
+	nonFinite         NonFinitePolicy
+	transforms        map[string][]func(any) any
+
+	cardinalityLimit int
+	cardinalityWarn  func(path string)
+	sampleRate       float64
+	clock            func() time.Time
+	initWarn         func()
+	strictSeal       bool
+
+	parseUserAgent          UserAgentParser
+	capturedRequestHeaders  []string
+	capturedResponseHeaders []string
+	selfSize                bool
+	runtimeStats            bool
+	keyCount                bool
+	sequence                bool
+	timestamps              bool
+	eventID                 bool
+	inflightTracking        bool
+	workerID                func(r *http.Request) string
+	routeGroup              func(r *http.Request) string
+	contextFields           map[any]string
+	baggageMembers          []string
+	statusClass             bool
+	errorCategory           bool
+	tlsInfo                 bool
+	clientCertInfo          bool
+	serverAddr              bool
+	traceContext            bool
+	disabledFields          map[string]bool
+	captureBodyBytes        int
+	flushInterval           time.Duration
+	onMarshalError          func(error)
+	marshalErrorPolicy      MarshalErrorPolicy
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{sampleRate: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// sampled reports whether a request should be logged according to the sample rate.
+func (o *options) sampled() bool {
+	return o.sampleRate >= 1 || rand.Float64() < o.sampleRate
+}
+
+// now returns the current time from the configured clock.
+func (o *options) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock()
+}
+
+// WithClock sets the function used to read the current time for checkpoints and request timings.  It defaults to
+// time.Now and is mainly useful for tests.
+func WithClock(clock func() time.Time) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithInitWarnFunc calls fn when Init or InitWithOptions is called on a context that is already initialized, other
+// than one returned by Disabled.  The existing context is still reused, but a redundant Init can point to a bug such as
+// a handler wrapped twice by the middleware.  fn is taken from the options of the call if set there, and otherwise from
+// those of the existing context.
+func WithInitWarnFunc(fn func()) Option {
+	return func(o *options) {
+		o.initWarn = fn
+	}
+}
+
+// WithStrictSeal makes writes to a sealed context panic instead of being ignored.  It is meant for tests and
+// development, where a write after the event was emitted should fail loudly.  Panics recovered by Go are the exception:
+// recording them after Seal is only counted, since a second panic there would crash the process.
+func WithStrictSeal() Option {
+	return func(o *options) {
+		o.strictSeal = true
+	}
+}
+
+// WithMaxDepth limits the nesting depth of keys to n levels.  Parts of a key beyond the limit are joined into the
+// deepest allowed key, so "a.b.c.d" with a max depth of 2 is stored as {"a":{"b.c.d":...}}.  A value of zero or less
+// means no limit.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// WithMaxValueBytes truncates string values longer than n bytes when they are set.  Truncation never splits a UTF-8
+// encoded character.  A value of zero or less means no limit.
+func WithMaxValueBytes(n int) Option {
+	return func(o *options) {
+		o.maxValueBytes = n
+	}
+}
+
+// WithMaxEventBytes bounds the size of the marshaled event to n bytes.  While the event is larger, the most recently
+// added values are dropped and clog.truncated is set to true, so fields recorded early, such as the request method and
+// path, are kept.  Values are never removed from the context itself, only from the marshaled output.  A value of zero
+// or less means no limit.
+func WithMaxEventBytes(n int) Option {
+	return func(o *options) {
+		o.maxEventBytes = n
+	}
+}
+
+// WithMaxKeys bounds the number of values in the marshaled event to n.  Values beyond the first n, in the order they
+// were added, are dropped from the output and their number is recorded in clog.dropped_keys.  A value of zero or less
+// means no limit.
+func WithMaxKeys(n int) Option {
+	return func(o *options) {
+		o.maxKeys = n
+	}
+}
+
+// WithHTMLEscape controls whether <, > and & in the marshaled JSON are escaped as \u003c, \u003e and \u0026, as
+// encoding/json does by default.  Disabling escaping makes values such as SQL or URLs easier to read in log viewers;
+// it is safe as long as events are not embedded in HTML.
+func WithHTMLEscape(escape bool) Option {
+	return func(o *options) {
+		o.noHTMLEscape = !escape
+	}
+}
+
+// WithSanitizeValues removes control characters from string values when they are set, reducing the risk of log
+// injection in pipelines that process events line by line.  Newlines, carriage returns and tabs are replaced with
+// spaces and all other control characters are dropped.
+func WithSanitizeValues() Option {
+	return func(o *options) {
+		o.sanitizeValues = true
+	}
+}
+
+// WithDurationStringFormat makes SetDuration store durations in the time.Duration.String form, such as "1.2s",
+// instead of as a number of milliseconds.
+func WithDurationStringFormat() Option {
+	return func(o *options) {
+		o.durationString = true
+	}
+}
+
+// WithSourceAnnotations records the file and line of the code that set each value in a sibling key named
+// <leaf>.__source, such as {"user":{"id":"42","id.__source":"handler.go:31"}}.  Calls made from within this package,
+// like those of the middleware, are attributed to their first caller outside of it.  Looking up the caller is
+// expensive and doubles the size of the event, so this is meant for debugging only.
+func WithSourceAnnotations() Option {
+	return func(o *options) {
+		o.sourceAnnotations = true
+	}
+}
+
+// WithTraceWriter writes a line to w for every write to the event, such as "SET http.request.method=GET" or
+// "ADD db.queries=1", in the order they happen.  The trace shows overwrites and ordering that the final event hides,
+// so it is meant for debugging only.  Lines are written while the context is locked; when the option is shared by
+// the middleware across requests, w must be safe for concurrent use.
+func WithTraceWriter(w io.Writer) Option {
+	return func(o *options) {
+		o.traceWriter = w
+	}
+}
+
+// WithRedactKeys replaces the values of the given keys with "[REDACTED]" when the event is marshaled.  Redacting a key
+// that holds nested values redacts the whole subtree.
+func WithRedactKeys(keys ...string) Option {
+	return func(o *options) {
+		for _, key := range keys {
+			o.redactKeys = append(o.redactKeys, strings.Split(strings.ToLower(key), "."))
+		}
+	}
+}
+
+// WithRedactUnlessError redacts the given keys like WithRedactKeys unless http.response.status_code is 400 or above
+// when the event is marshaled, so failed requests keep full detail for debugging.  Events without a status code, such as
+// periodic snapshots taken while the handler runs, are redacted.
+func WithRedactUnlessError(keys ...string) Option {
+	return func(o *options) {
+		for _, key := range keys {
+			o.redactUnlessError = append(o.redactUnlessError, strings.Split(strings.ToLower(key), "."))
+		}
+	}
+}
+
+// WithValueTransform normalizes values as they are set at path, such as lowercasing a method or bucketing user IDs.  fn
+// receives the value being set and returns the value to store.  Transforms registered for the same path run in the
+// order they were added.  Values accumulated or compared with functions like AddInt, SetMaxInt and OrBool are not
+// transformed.
+func WithValueTransform(path string, fn func(any) any) Option {
+	return func(o *options) {
+		if o.transforms == nil {
+			o.transforms = make(map[string][]func(any) any)
+		}
+		path = strings.ToLower(path)
+		o.transforms[path] = append(o.transforms[path], fn)
+	}
+}
+
+// WithKeyAliases renames keys when the event is marshaled, mapping canonical key paths to the names expected by a
+// backend.  For example, {"http.response.status_code": "http.response.status"} renames the status code field.  Aliasing
+// a key that holds nested values moves the whole subtree.  A key renamed within the same parent keeps its position;
+// otherwise it is appended to its new parent.  Aliases are applied in sorted order of their source keys and after
+// redaction, so redacted keys are referenced by their canonical names.
+func WithKeyAliases(aliases map[string]string) Option {
+	return func(o *options) {
+		from := make([]string, 0, len(aliases))
+		for key := range aliases {
+			from = append(from, key)
+		}
+		sort.Strings(from)
+		for _, key := range from {
+			o.aliases = append(o.aliases, alias{
+				from: strings.Split(strings.ToLower(key), "."),
+				to:   strings.Split(strings.ToLower(aliases[key]), "."),
+			})
+		}
+	}
+}
+
+// WritePolicy controls what happens when a setter writes to a key that already exists.
+type WritePolicy int
+
+const (
+	// LastWins overwrites existing values.  This is the default.
+	LastWins WritePolicy = iota
+	// FirstWins ignores writes to keys that already exist.
+	FirstWins
+)
+
+// WithWritePolicy sets the policy applied by SetString, SetInt, SetFloat64 and the other setters when a key already
+// exists.  Under FirstWins every setter behaves like GetOrSet, which is useful for libraries whose defaults must not be
+// overridden.  Accumulating functions such as AddInt, SetMaxInt and AppendObject are not affected by the policy.
+func WithWritePolicy(policy WritePolicy) Option {
+	return func(o *options) {
+		o.writePolicy = policy
+	}
+}
+
+// WithRollupTotals makes AddInt, AddFloat64 and AddIntPath also add the value to a total key at every level above the
+// key, so AddInt(ctx, "db.queries.users", 1) increments db.queries.total and db.total as well.  Each add then updates
+// one key per level of nesting, and the total keys count toward limits such as WithMaxKeys.  A total that receives
+// both ints and floats becomes a float64.
+func WithRollupTotals() Option {
+	return func(o *options) {
+		o.rollupTotals = true
+	}
+}
+
+// NonFinitePolicy controls how NaN and infinite float values are stored.  JSON cannot represent them, so by default an
+// event holding one fails to marshal.
+type NonFinitePolicy int
+
+const (
+	// NonFiniteKeep stores non-finite values as-is, which makes marshaling the event to JSON fail.  This is the default.
+	NonFiniteKeep NonFinitePolicy = iota
+	// NonFiniteString stores non-finite values as the strings "NaN", "+Inf" and "-Inf".
+	NonFiniteString
+	// NonFiniteDrop removes the key instead of storing a non-finite value.
+	NonFiniteDrop
+	// NonFiniteError removes the key like NonFiniteDrop and records the key in clog.error.
+	NonFiniteError
+)
+
+// WithNonFiniteFloats sets the policy applied when a NaN or infinite float is set or produced by AddFloat64.
+func WithNonFiniteFloats(policy NonFinitePolicy) Option {
+	return func(o *options) {
+		o.nonFinite = policy
+	}
+}
+
+// WithCardinalityWarnFunc calls fn with the dot-separated path of a parent key when more than limit distinct keys are
+// set directly beneath it within one event.  This catches loops that set per-ID keys, which cause cardinality
+// explosions in log backends.  The root of the event is reported as an empty path.  fn is called at most once per parent
+// and while the context is locked, so it must not call other clog functions on the same context.
+func WithCardinalityWarnFunc(limit int, fn func(path string)) Option {
+	return func(o *options) {
+		o.cardinalityLimit = limit
+		o.cardinalityWarn = fn
+	}
+}
+
+// WithSampleRate configures the middleware to log only a fraction of requests.  rate is between 0 and 1.  Requests
+// that are not sampled are served with a Disabled context and no event is emitted.
+func WithSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.sampleRate = rate
+	}
+}
+
+// OptionsFromEnv returns options configured by environment variables.  It reads:
+//
+//	CLOG_SAMPLE_RATE      fraction of requests to log, from 0 to 1, see WithSampleRate
+//	CLOG_MAX_VALUE_BYTES  maximum size of string values, see WithMaxValueBytes
+//	CLOG_MAX_DEPTH        maximum nesting depth of keys, see WithMaxDepth
+//	CLOG_REDACT_KEYS      comma-separated keys to redact, see WithRedactKeys
+//
+// Unset variables are ignored.  An error is returned if a variable cannot be parsed.
+func OptionsFromEnv() ([]Option, error) {
+	var opts []Option
+
+	if v, ok := os.LookupEnv("CLOG_SAMPLE_RATE"); ok {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("clog: invalid CLOG_SAMPLE_RATE: %w", err)
+		}
+		if !(rate >= 0 && rate <= 1) {
+			return nil, fmt.Errorf("clog: invalid CLOG_SAMPLE_RATE: %v is not between 0 and 1", v)
+		}
+		opts = append(opts, WithSampleRate(rate))
+	}
+
+	if v, ok := os.LookupEnv("CLOG_MAX_VALUE_BYTES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("clog: invalid CLOG_MAX_VALUE_BYTES: %w", err)
+		}
+		opts = append(opts, WithMaxValueBytes(n))
+	}
+
+	if v, ok := os.LookupEnv("CLOG_MAX_DEPTH"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("clog: invalid CLOG_MAX_DEPTH: %w", err)
+		}
+		opts = append(opts, WithMaxDepth(n))
+	}
+
+	if v, ok := os.LookupEnv("CLOG_REDACT_KEYS"); ok {
+		var keys []string
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		opts = append(opts, WithRedactKeys(keys...))
+	}
+
+	return opts, nil
+}
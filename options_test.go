@@ -0,0 +1,327 @@
+package clog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("CLOG_SAMPLE_RATE", "0.25")
+	t.Setenv("CLOG_MAX_VALUE_BYTES", "64")
+	t.Setenv("CLOG_MAX_DEPTH", "4")
+	t.Setenv("CLOG_REDACT_KEYS", "user.email, http.request.headers.authorization")
+
+	opts, err := OptionsFromEnv()
+	require.NoError(t, err)
+
+	o := newOptions(opts)
+	require.Equal(t, 0.25, o.sampleRate)
+	require.Equal(t, 64, o.maxValueBytes)
+	require.Equal(t, 4, o.maxDepth)
+	require.Equal(t, [][]string{{"user", "email"}, {"http", "request", "headers", "authorization"}}, o.redactKeys)
+}
+
+func TestOptionsFromEnv_Unset(t *testing.T) {
+	opts, err := OptionsFromEnv()
+	require.NoError(t, err)
+	require.Empty(t, opts)
+}
+
+func TestOptionsFromEnv_Invalid(t *testing.T) {
+	t.Setenv("CLOG_MAX_VALUE_BYTES", "lots")
+
+	_, err := OptionsFromEnv()
+	require.ErrorContains(t, err, "CLOG_MAX_VALUE_BYTES")
+}
+
+func TestOptionsFromEnv_SampleRateRange(t *testing.T) {
+	for _, v := range []string{"50", "-1", "1.01", "NaN"} {
+		t.Setenv("CLOG_SAMPLE_RATE", v)
+		_, err := OptionsFromEnv()
+		require.ErrorContains(t, err, "CLOG_SAMPLE_RATE", v)
+	}
+	for _, v := range []string{"0", "1"} {
+		t.Setenv("CLOG_SAMPLE_RATE", v)
+		_, err := OptionsFromEnv()
+		require.NoError(t, err, v)
+	}
+}
+
+func TestWithMaxValueBytes(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithMaxValueBytes(5))
+	SetString(ctx, "short", "abc")
+	SetString(ctx, "long", "abcdefgh")
+	SetString(ctx, "utf8", "abcdé")
+
+	require.Equal(t, `{"short":"abc","long":"abcde","utf8":"abcd"}`, MarshalJSON(ctx))
+}
+
+func TestWithRedactKeys(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRedactKeys("user.email", "Secrets"))
+	SetString(ctx, "user.id", "123")
+	SetString(ctx, "user.email", "jane@example.com")
+	SetString(ctx, "secrets.token", "abc")
+
+	require.Equal(t, `{"user":{"id":"123","email":"[REDACTED]"},"secrets":"[REDACTED]"}`, MarshalJSON(ctx))
+
+	Walk(ctx, func(path []string, value any) {
+		require.NotEqual(t, "[REDACTED]", value, "redaction must not modify the live event")
+	})
+}
+
+func TestWithKeyAliases(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithKeyAliases(map[string]string{
+		"http.response.status_code": "http.response.status",
+		"http.request.path":         "url.path",
+		"user":                      "client.user",
+	}))
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.path", "/foo")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetInt(ctx, "http.response.duration_ms", 5)
+	SetString(ctx, "user.id", "123")
+
+	require.Equal(t, `{"http":{"request":{"method":"GET"},"response":{"status":200,"duration_ms":5}},"url":{"path":"/foo"},"client":{"user":{"id":"123"}}}`, MarshalJSON(ctx))
+}
+
+func TestWithWritePolicy(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithWritePolicy(LastWins))
+	SetString(ctx, "db.driver", "postgres")
+	SetString(ctx, "db.driver", "mysql")
+	require.Equal(t, `{"db":{"driver":"mysql"}}`, MarshalJSON(ctx))
+
+	ctx = InitWithOptions(context.Background(), WithWritePolicy(FirstWins))
+	SetString(ctx, "db.driver", "postgres")
+	SetString(ctx, "db.driver", "mysql")
+	SetInt(ctx, "db.pool_size", 10)
+	SetInt(ctx, "db.pool_size", 20)
+	AddInt(ctx, "db.queries", 1)
+	AddInt(ctx, "db.queries", 1)
+	require.Equal(t, `{"db":{"driver":"postgres","pool_size":10,"queries":2}}`, MarshalJSON(ctx))
+
+	restore := WithTempFields(ctx, map[string]any{"db.table": "users"})
+	restore()
+	require.Equal(t, `{"db":{"driver":"postgres","pool_size":10,"queries":2}}`, MarshalJSON(ctx))
+}
+
+func TestWithCardinalityWarnFunc(t *testing.T) {
+	var warnings []string
+	ctx := InitWithOptions(context.Background(), WithCardinalityWarnFunc(3, func(path string) {
+		warnings = append(warnings, path)
+	}))
+
+	SetString(ctx, "user.id", "123")
+	for i := 0; i < 3; i++ {
+		SetInt(ctx, fmt.Sprintf("items.item_%d.qty", i), i)
+	}
+	require.Empty(t, warnings)
+
+	for i := 3; i < 10; i++ {
+		AddInt(ctx, fmt.Sprintf("items.item_%d.qty", i), i)
+	}
+	SetInt(ctx, "items.item_0.qty", 5)
+	require.Equal(t, []string{"items"}, warnings)
+}
+
+func TestWithSanitizeValues(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithSanitizeValues())
+	SetString(ctx, "user.name", "admin\n{\"level\":\"error\"}")
+	SetString(ctx, "user.agent", "curl\x00/8.4\x1b[31m\r\n")
+
+	require.Equal(t, `{"user":{"name":"admin {\"level\":\"error\"}","agent":"curl/8.4[31m  "}}`, MarshalJSON(ctx))
+
+	ctx = Init(context.Background())
+	SetString(ctx, "user.name", "admin\n")
+	require.Equal(t, `{"user":{"name":"admin\n"}}`, MarshalJSON(ctx))
+}
+
+func TestWithSanitizeValues_Nested(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithSanitizeValues(), WithMaxValueBytes(8))
+	headers := []string{"one\x01two", strings.Repeat("z", 50)}
+	setValue(ctx, "http.request.headers.x-a", headers)
+	AddValidationError(ctx, "name", "bad\nvalue\x00")
+	RecordRetry(ctx, "payments", "oops\r\ninjected")
+	SetSubEvent(ctx, "sub", `{"msg":"a\u0000b\nc","list":["x\ty"]}`)
+
+	require.Equal(t, `{"http":{"request":{"headers":{"x-a":["onetwo","zzzzzzzz"]}},"response":{"validation_errors":[{"field":"name","message":"bad valu"}],"validation_error_count":1}},"payments":{"retries":1,"retry_reasons":["oops  in"]},"sub":{"msg":"ab c","list":["x y"]}}`, MarshalJSON(ctx))
+	require.Equal(t, "one\x01two", headers[0])
+}
+
+func TestWithMaxEventBytes(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithMaxEventBytes(80))
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.path", "/orders")
+	require.Equal(t, `{"http":{"request":{"method":"GET","path":"/orders"}}}`, MarshalJSON(ctx))
+
+	SetString(ctx, "db.statement", strings.Repeat("x", 100))
+	SetInt(ctx, "db.rows", 3)
+	event := MarshalJSON(ctx)
+	require.Equal(t, `{"http":{"request":{"method":"GET","path":"/orders"}},"clog":{"truncated":true}}`, event)
+	require.LessOrEqual(t, len(event), 80)
+
+	v, ok := Snapshot(ctx).Int("db.rows")
+	require.True(t, ok)
+	require.Equal(t, 3, v)
+}
+
+func TestWithMaxEventBytes_ManyKeys(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithMaxEventBytes(200))
+	for i := 0; i < 5000; i++ {
+		SetInt(ctx, fmt.Sprintf("group%d.key%d", i%10, i), i)
+	}
+
+	start := time.Now()
+	event := MarshalJSON(ctx)
+	require.Less(t, time.Since(start), time.Second)
+	require.LessOrEqual(t, len(event), 200)
+	require.Contains(t, event, `"clog":{"truncated":true}`)
+	require.True(t, strings.HasPrefix(event, `{"group0":{"key0":0,"key10":10,`), event)
+}
+
+func TestWithSourceAnnotations(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithSourceAnnotations())
+	_, file, line, _ := runtime.Caller(0)
+	SetString(ctx, "user.id", "42")
+	SetInt(ctx, "count", 1)
+
+	source := fmt.Sprintf("%s:%d", filepath.Base(file), line+1)
+	count := fmt.Sprintf("%s:%d", filepath.Base(file), line+2)
+	require.Equal(t, fmt.Sprintf(`{"user":{"id":"42","id.__source":%q},"count":1,"count.__source":%q}`, source, count), MarshalJSON(ctx))
+}
+
+func TestWithNonFiniteFloats(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "user.id", "42")
+	SetFloat64(ctx, "ratio", math.NaN())
+	require.Equal(t, "", MarshalJSON(ctx))
+
+	tests := []struct {
+		policy   NonFinitePolicy
+		expected string
+	}{
+		{NonFiniteString, `{"user":{"id":"42"},"ratio":"NaN","db":{"load":"+Inf"}}`},
+		{NonFiniteDrop, `{"user":{"id":"42"}}`},
+		{NonFiniteError, `{"user":{"id":"42"},"clog":{"error":"db.load: non-finite float +Inf"}}`},
+	}
+	for _, tt := range tests {
+		ctx := InitWithOptions(context.Background(), WithNonFiniteFloats(tt.policy))
+		SetString(ctx, "user.id", "42")
+		SetFloat64(ctx, "ratio", math.NaN())
+		AddFloat64(ctx, "db.load", math.MaxFloat64)
+		AddFloat64(ctx, "db.load", math.MaxFloat64)
+		require.Equal(t, tt.expected, MarshalJSON(ctx))
+	}
+}
+
+func TestWithRollupTotals(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRollupTotals())
+	AddInt(ctx, "db.queries.users", 1)
+	AddInt(ctx, "db.queries.orders", 2)
+	AddIntPath(ctx, 3, "db", "queries", "users")
+	AddInt(ctx, "db.errors", 1)
+	AddInt(ctx, "requests", 5)
+	AddFloat64(ctx, "cost.cpu", 0.5)
+	AddFloat64(ctx, "cost.io", 0.25)
+	require.Equal(t, `{"db":{"queries":{"users":4,"total":6,"orders":2},"total":7,"errors":1},"requests":5,"cost":{"cpu":0.5,"total":0.75,"io":0.25}}`, MarshalJSON(ctx))
+}
+
+func TestWithRollupTotals_Mixed(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRollupTotals())
+	AddInt(ctx, "a.b.c", 1)
+	AddFloat64(ctx, "a.b.d", 1.5)
+	AddInt(ctx, "a.b.e", 2)
+	require.Equal(t, `{"a":{"b":{"c":1,"total":4.5,"d":1.5,"e":2},"total":4.5}}`, MarshalJSON(ctx))
+}
+
+func TestWithRollupTotals_Rejected(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRollupTotals())
+	SetString(ctx, "db.q.users", "x")
+	AddInt(ctx, "db.q.users", 1)
+	AddFloat64(ctx, "db.q.users", 1.5)
+	AddIntPath(ctx, 1, "db", "q", "users")
+	require.Equal(t, `{"db":{"q":{"users":"x"}}}`, MarshalJSON(ctx))
+
+	ctx = InitWithOptions(context.Background(), WithRollupTotals())
+	Seal(ctx)
+	AddInt(ctx, "db.q.users", 1)
+	require.Equal(t, `{"clog":{"write_after_seal_count":1}}`, MarshalJSON(ctx))
+}
+
+func TestWithValueTransform_Accumulators(t *testing.T) {
+	double := func(v any) any {
+		if n, ok := v.(int); ok {
+			return n * 2
+		}
+		return v
+	}
+	ctx := InitWithOptions(context.Background(),
+		WithValueTransform("set", double), WithValueTransform("max", double), WithValueTransform("count", double))
+	SetInt(ctx, "set", 1)
+	SetMaxInt(ctx, "max", 3)
+	SetMaxInt(ctx, "max", 5)
+	AddInt(ctx, "count", 2)
+	require.Equal(t, `{"set":2,"max":5,"count":2}`, MarshalJSON(ctx))
+}
+
+func TestWithHTMLEscape(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "db.query", `SELECT * FROM t WHERE a < 1 AND b > 2 AND c = '&'`)
+	require.Equal(t, `{"db":{"query":"SELECT * FROM t WHERE a \u003c 1 AND b \u003e 2 AND c = '\u0026'"}}`, MarshalJSON(ctx))
+
+	ctx = InitWithOptions(context.Background(), WithHTMLEscape(false))
+	SetString(ctx, "db.query", `SELECT * FROM t WHERE a < 1 AND b > 2 AND c = '&'`)
+	SetString(ctx, "path", `C:\u003c "quoted"`)
+	AppendObject(ctx, "links", "href", "/a?x=1&y=2")
+	event := MarshalJSON(ctx)
+	require.Equal(t, `{"db":{"query":"SELECT * FROM t WHERE a < 1 AND b > 2 AND c = '&'"},"path":"C:\\u003c \"quoted\"","links":[{"href":"/a?x=1&y=2"}]}`, event)
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event), &decoded))
+	require.Equal(t, `C:\u003c "quoted"`, decoded["path"])
+	require.Equal(t, `{"query":"SELECT * FROM t WHERE a < 1 AND b > 2 AND c = '&'"}`, MarshalSubtreeJSON(ctx, "db"))
+}
+
+func TestWithTraceWriter(t *testing.T) {
+	var trace strings.Builder
+	ctx := InitWithOptions(context.Background(), WithTraceWriter(&trace))
+	SetString(ctx, "http.request.method", "GET")
+	SetString(ctx, "http.request.method", "POST")
+	AddInt(ctx, "db.queries", 1)
+	AddFloat64(ctx, "db.duration_ms", 1.5)
+	AppendObject(ctx, "db.tables", "name", "users")
+	MarshalJSON(ctx)
+
+	require.Equal(t, `SET http.request.method=GET
+SET http.request.method=POST
+ADD db.queries=1
+ADD db.duration_ms=1.5
+APPEND db.tables={"name":"users"}
+`, trace.String())
+}
+
+func TestWithInitWarnFunc(t *testing.T) {
+	warnings := 0
+	warn := WithInitWarnFunc(func() { warnings++ })
+
+	ctx := InitWithOptions(context.Background(), warn)
+	require.Equal(t, 0, warnings)
+	require.Equal(t, ctx, Init(ctx))
+	require.Equal(t, 1, warnings)
+	InitWithOptions(ctx, WithMaxDepth(2))
+	require.Equal(t, 2, warnings)
+
+	other := Init(context.Background())
+	InitWithOptions(other, warn)
+	require.Equal(t, 3, warnings)
+	InitWithOptions(Disabled(context.Background()), warn)
+	require.Equal(t, 3, warnings)
+}
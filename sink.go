@@ -0,0 +1,393 @@
+package clog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultSink atomic.Pointer[func(string)]
+
+// SetDefaultSink sets the process-wide logFn used by Flush.  A nil sink disables Flush.  It is safe to call
+// concurrently with Flush; each Flush uses the sink that was set when it was called, so sink must itself be safe for
+// concurrent use.
+func SetDefaultSink(sink func(string)) {
+	if sink == nil {
+		defaultSink.Store(nil)
+		return
+	}
+	defaultSink.Store(&sink)
+}
+
+// Flush emits the current event in ctx to the default sink and resets it, as SwapAndMarshal does.  If the event cannot
+// be marshaled, the fallback event of WithMarshalErrorPolicy is emitted in its place, as the middleware does.  If no
+// default sink is set, the event is left untouched.  Nothing is emitted if ctx is not initialized.
+func Flush(ctx context.Context) {
+	sink := defaultSink.Load()
+	if sink == nil {
+		return
+	}
+	if c, ok := fromContext(ctx); ok {
+		swapAndEmit(ctx, c.opts, *sink)
+	}
+}
+
+// FileSink appends events to a file as JSON lines.  It is safe for concurrent use.
+type FileSink struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens path for appending and returns a logFn that writes each event as a line to the file, along with
+// the sink for reopening and closing it.  Call Reopen after the file has been rotated, for example from a SIGHUP
+// handler when using logrotate:
+//
+//	logFn, sink, err := clog.NewFileSink("/var/log/app/events.log")
+//	...
+//	hup := make(chan os.Signal, 1)
+//	signal.Notify(hup, syscall.SIGHUP)
+//	go func() {
+//		for range hup {
+//			_ = sink.Reopen()
+//		}
+//	}()
+func NewFileSink(path string) (func(string), *FileSink, error) {
+	s := &FileSink{path: path}
+	if err := s.Reopen(); err != nil {
+		return nil, nil, err
+	}
+	return s.Log, s, nil
+}
+
+// Log appends event to the file followed by a newline.  Empty events and events logged after Close are dropped.
+func (s *FileSink) Log(event string) {
+	if event == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return
+	}
+	_, _ = s.f.WriteString(event + "\n")
+}
+
+// Reopen closes the current file and opens the path again, creating it if needed.
+func (s *FileSink) Reopen() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		_ = s.f.Close()
+	}
+	s.f = f
+	return nil
+}
+
+// Close closes the file.  Events logged after Close are dropped.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// NewRingSink returns a logFn that keeps the n most recently emitted events in memory and a dump function that
+// returns them, oldest first.  It is safe for concurrent use and is intended for in-process debugging, such as a
+// /debug/clog endpoint.
+func NewRingSink(n int) (logFn func(string), dump func() []string) {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	var mu sync.Mutex
+	ring := make([]string, n)
+	next, count := 0, 0
+
+	logFn = func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ring[next] = event
+		next = (next + 1) % n
+		count = min(count+1, n)
+	}
+	dump = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		events := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			events = append(events, ring[(next-count+i+n)%n])
+		}
+		return events
+	}
+	return logFn, dump
+}
+
+// Dedup returns a logFn that passes events to inner but suppresses events identical to the previous one that arrive
+// within window of when it was last passed on.  When a different event arrives, or the window elapses, the number of
+// suppressed events is first reported to inner as {"repeated":N}.  Suppressed events that are never followed by another
+// event are not reported.  It is safe for concurrent use.
+func Dedup(inner func(string), window time.Duration) func(string) {
+	var mu sync.Mutex
+	var last string
+	var lastEmitted time.Time
+	repeated := 0
+
+	return func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if event == last && now.Sub(lastEmitted) < window {
+			repeated++
+			return
+		}
+		if repeated > 0 {
+			inner(`{"repeated":` + strconv.Itoa(repeated) + `}`)
+			repeated = 0
+		}
+		inner(event)
+		last, lastEmitted = event, now
+	}
+}
+
+// AsyncSink writes events to an io.Writer as JSON lines from a background goroutine so that logging never blocks the
+// caller.  Events logged while the buffer is full are dropped and counted.
+type AsyncSink struct {
+	events  chan string
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	err    error
+}
+
+// NewAsyncSink returns a logFn that queues events for writing to w and the sink for closing it.  Up to bufferSize
+// events are queued; events logged while the queue is full are dropped and counted in Dropped.  Close flushes the queue
+// and must be called before the process exits to avoid losing events.
+func NewAsyncSink(w io.Writer, bufferSize int) (func(string), *AsyncSink) {
+	if bufferSize <= 0 {
+		panic("bufferSize must be positive")
+	}
+
+	s := &AsyncSink{
+		events: make(chan string, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		for event := range s.events {
+			if _, err := io.WriteString(w, event+"\n"); err != nil && s.err == nil {
+				s.err = err
+			}
+		}
+	}()
+	return s.Log, s
+}
+
+// Log queues event for writing.  Empty events are ignored and events logged after Close are dropped.
+func (s *AsyncSink) Log(event string) {
+	if event == "" {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full or the sink was closed.
+func (s *AsyncSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops accepting events, waits for the queued events to be written and returns the first write error, if any.
+func (s *AsyncSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	return s.err
+}
+
+// httpSinkRetries is the number of times a batch is retried after a failed POST before it is dropped.
+const httpSinkRetries = 3
+
+// httpSinkBackoff is the delay before the first retry of a failed POST.  It doubles for each later retry.
+var httpSinkBackoff = 100 * time.Millisecond
+
+// HTTPSink batches events and POSTs them to a collector from a background goroutine so that logging never blocks the
+// caller.  Events logged while the queue is full are dropped and counted.
+type HTTPSink struct {
+	url     string
+	client  *http.Client
+	events  chan string
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	err    error
+}
+
+// NewHTTPSink returns a logFn that batches events and POSTs them to url as newline-delimited JSON, along with the sink
+// for closing it.  A batch is sent once it holds batchSize events or flushInterval has passed since the last send; a
+// flushInterval of zero only sends full batches.  POSTs that fail with a network error or a 5xx response are retried
+// with exponential backoff before the batch is dropped; other responses outside 2xx drop the batch immediately.  Up to
+// 4*batchSize events are queued; events logged while the queue is full are dropped and counted in Dropped.  Close
+// must be called before the process exits to avoid losing events.
+func NewHTTPSink(url string, batchSize int, flushInterval time.Duration) (func(string), *HTTPSink) {
+	if batchSize <= 0 {
+		panic("batchSize must be positive")
+	}
+
+	s := &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan string, 4*batchSize),
+		done:   make(chan struct{}),
+	}
+	go s.run(batchSize, flushInterval)
+	return s.Log, s
+}
+
+// Log queues event for the next batch.  Empty events are ignored and events logged after Close are dropped.
+func (s *HTTPSink) Log(event string) {
+	if event == "" {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full or the sink was closed.  Events in batches
+// that could not be delivered are reported by Close instead.
+func (s *HTTPSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops accepting events, sends the pending batch and returns the first delivery error, if any.
+func (s *HTTPSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	return s.err
+}
+
+func (s *HTTPSink) run(batchSize int, flushInterval time.Duration) {
+	defer close(s.done)
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if flushInterval > 0 {
+		ticker = time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var batch bytes.Buffer
+	n := 0
+	send := func() {
+		if ticker != nil {
+			ticker.Reset(flushInterval)
+		}
+		if n == 0 {
+			return
+		}
+		if err := s.post(batch.Bytes()); err != nil && s.err == nil {
+			s.err = err
+		}
+		batch.Reset()
+		n = 0
+	}
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				send()
+				return
+			}
+			batch.WriteString(event)
+			batch.WriteByte('\n')
+			if n++; n >= batchSize {
+				send()
+			}
+		case <-tick:
+			send()
+		}
+	}
+}
+
+// post sends body to the collector, retrying network errors and 5xx responses with exponential backoff.
+func (s *HTTPSink) post(body []byte) error {
+	backoff := httpSinkBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.postOnce(body)
+		if err == nil || !retry || attempt == httpSinkRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postOnce sends body to the collector once and reports whether a failure is worth retrying.
+func (s *HTTPSink) postOnce(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("clog: POST %s: %s", s.url, resp.Status)
+	}
+	return false, nil
+}
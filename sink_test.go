@@ -0,0 +1,303 @@
+package clog
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	logFn, sink, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	logFn(`{"n":1}`)
+	logFn("")
+	logFn(`{"n":2}`)
+	logFn(`{"n":3}`)
+	require.NoError(t, sink.Close())
+	logFn(`{"n":4}`)
+
+	require.Equal(t, []string{`{"n":1}`, `{"n":2}`, `{"n":3}`}, readLines(t, path))
+}
+
+func TestFileSink_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.log")
+	logFn, sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	logFn(`{"n":1}`)
+	require.NoError(t, os.Rename(path, filepath.Join(dir, "events.log.1")))
+	require.NoError(t, sink.Reopen())
+	logFn(`{"n":2}`)
+
+	require.Equal(t, []string{`{"n":1}`}, readLines(t, filepath.Join(dir, "events.log.1")))
+	require.Equal(t, []string{`{"n":2}`}, readLines(t, path))
+}
+
+func TestRingSink(t *testing.T) {
+	logFn, dump := NewRingSink(3)
+	require.Empty(t, dump())
+
+	logFn(`{"n":1}`)
+	logFn(`{"n":2}`)
+	require.Equal(t, []string{`{"n":1}`, `{"n":2}`}, dump())
+
+	logFn(`{"n":3}`)
+	logFn(`{"n":4}`)
+	logFn(`{"n":5}`)
+	require.Equal(t, []string{`{"n":3}`, `{"n":4}`, `{"n":5}`}, dump())
+}
+
+func TestRingSink_InvalidSize(t *testing.T) {
+	require.PanicsWithValue(t, "n must be positive", func() {
+		NewRingSink(0)
+	})
+}
+
+func TestFlush(t *testing.T) {
+	var events []string
+	SetDefaultSink(func(event string) {
+		events = append(events, event)
+	})
+	defer SetDefaultSink(nil)
+
+	ctx := Init(context.Background())
+	SetString(ctx, "foo", "bar")
+	Flush(ctx)
+	SetInt(ctx, "n", 1)
+	Flush(ctx)
+	Flush(context.Background())
+	require.Equal(t, []string{`{"foo":"bar"}`, `{"n":1}`}, events)
+
+	SetDefaultSink(nil)
+	SetInt(ctx, "n", 2)
+	Flush(ctx)
+	require.Len(t, events, 2)
+	require.Equal(t, `{"n":2}`, MarshalJSON(ctx))
+}
+
+func TestFlush_MarshalError(t *testing.T) {
+	var events []string
+	SetDefaultSink(func(event string) {
+		events = append(events, event)
+	})
+	defer SetDefaultSink(nil)
+
+	var errs []error
+	ctx := InitWithOptions(context.Background(), WithOnMarshalError(func(err error) { errs = append(errs, err) }))
+	SetFloat64(ctx, "ratio", math.NaN())
+	Flush(ctx)
+	require.Equal(t, []string{`{"clog":{"error":"marshal_failed"}}`}, events)
+	require.Len(t, errs, 1)
+
+	ctx = InitWithOptions(context.Background(), WithMarshalErrorPolicy(MarshalErrorSkip))
+	SetFloat64(ctx, "ratio", math.NaN())
+	Flush(ctx)
+	require.Len(t, events, 1)
+}
+
+func TestDedup(t *testing.T) {
+	var events []string
+	logFn := Dedup(func(event string) {
+		events = append(events, event)
+	}, time.Hour)
+
+	logFn(`{"n":1}`)
+	logFn(`{"n":1}`)
+	logFn(`{"n":1}`)
+	logFn(`{"n":2}`)
+	logFn(`{"n":1}`)
+	require.Equal(t, []string{`{"n":1}`, `{"repeated":2}`, `{"n":2}`, `{"n":1}`}, events)
+}
+
+func TestDedup_WindowElapsed(t *testing.T) {
+	var events []string
+	logFn := Dedup(func(event string) {
+		events = append(events, event)
+	}, 0)
+
+	logFn(`{"n":1}`)
+	logFn(`{"n":1}`)
+	require.Equal(t, []string{`{"n":1}`, `{"n":1}`}, events)
+}
+
+// blockingWriter signals started on its first write and then blocks until release is closed.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	buf     strings.Builder
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	return w.buf.Write(p)
+}
+
+func TestAsyncSink(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	logFn, sink := NewAsyncSink(w, 2)
+
+	logFn(`{"n":1}`)
+	<-w.started
+	logFn(`{"n":2}`)
+	logFn(`{"n":3}`)
+	logFn(`{"n":4}`)
+	logFn(`{"n":5}`)
+	require.Equal(t, uint64(2), sink.Dropped())
+
+	close(w.release)
+	require.NoError(t, sink.Close())
+	require.Equal(t, "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n", w.buf.String())
+
+	logFn(`{"n":6}`)
+	require.Equal(t, uint64(3), sink.Dropped())
+	require.NoError(t, sink.Close())
+}
+
+func TestAsyncSink_InvalidSize(t *testing.T) {
+	require.PanicsWithValue(t, "bufferSize must be positive", func() {
+		NewAsyncSink(io.Discard, 0)
+	})
+}
+
+// collector is an httptest handler that records the bodies POSTed to it.  The first failures requests are answered
+// with status, or 503 if status is zero, and a non-nil block delays every response until it is closed.
+type collector struct {
+	mu       sync.Mutex
+	bodies   []string
+	requests int
+	failures int
+	status   int
+	block    chan struct{}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(cmp.Or(c.status, http.StatusServiceUnavailable))
+		return
+	}
+	b, _ := io.ReadAll(r.Body)
+	c.bodies = append(c.bodies, r.Header.Get("Content-Type")+" "+string(b))
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+func TestHTTPSink(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	logFn, sink := NewHTTPSink(srv.URL, 2, 0)
+	logFn(`{"n":1}`)
+	logFn(`{"n":2}`)
+	logFn(`{"n":3}`)
+	require.Eventually(t, func() bool { return len(c.received()) == 1 }, time.Second, time.Millisecond)
+
+	require.NoError(t, sink.Close())
+	require.Equal(t, []string{
+		"application/x-ndjson {\"n\":1}\n{\"n\":2}\n",
+		"application/x-ndjson {\"n\":3}\n",
+	}, c.received())
+
+	logFn(`{"n":4}`)
+	require.NoError(t, sink.Close())
+	require.Len(t, c.received(), 2)
+	require.Equal(t, uint64(1), sink.Dropped())
+}
+
+func TestHTTPSink_Dropped(t *testing.T) {
+	c := &collector{block: make(chan struct{})}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	logFn, sink := NewHTTPSink(srv.URL, 1, 0)
+	logFn(`{"n":0}`)
+	require.Eventually(t, func() bool { return len(sink.events) == 0 }, time.Second, time.Millisecond)
+	for i := 1; i <= 6; i++ {
+		logFn(fmt.Sprintf(`{"n":%d}`, i))
+	}
+	require.Equal(t, uint64(2), sink.Dropped())
+
+	close(c.block)
+	require.NoError(t, sink.Close())
+	require.Len(t, c.received(), 5)
+}
+
+func TestHTTPSink_FlushInterval(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	logFn, sink := NewHTTPSink(srv.URL, 100, 10*time.Millisecond)
+	defer sink.Close()
+	logFn(`{"n":1}`)
+	require.Eventually(t, func() bool { return len(c.received()) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestHTTPSink_Retry(t *testing.T) {
+	backoff := httpSinkBackoff
+	httpSinkBackoff = time.Millisecond
+	defer func() { httpSinkBackoff = backoff }()
+
+	c := &collector{failures: 2}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	logFn, sink := NewHTTPSink(srv.URL, 10, 0)
+	logFn(`{"n":1}`)
+	require.NoError(t, sink.Close())
+	require.Equal(t, []string{"application/x-ndjson {\"n\":1}\n"}, c.received())
+
+	c = &collector{failures: httpSinkRetries + 1}
+	srv2 := httptest.NewServer(c)
+	defer srv2.Close()
+	logFn, sink = NewHTTPSink(srv2.URL, 10, 0)
+	logFn(`{"n":1}`)
+	require.ErrorContains(t, sink.Close(), "503 Service Unavailable")
+	require.Empty(t, c.received())
+	require.Equal(t, httpSinkRetries+1, c.requests)
+
+	c = &collector{failures: 1, status: http.StatusBadRequest}
+	srv3 := httptest.NewServer(c)
+	defer srv3.Close()
+	logFn, sink = NewHTTPSink(srv3.URL, 10, 0)
+	logFn(`{"n":1}`)
+	require.ErrorContains(t, sink.Close(), "400 Bad Request")
+	require.Equal(t, 1, c.requests)
+}
@@ -0,0 +1,101 @@
+package clog
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ObserveInt records value in a summary under key.  The summary holds the number of observations in <key>.count,
+// their total in <key>.sum and the smallest and largest values in <key>.min and <key>.max.  This turns per-item values,
+// such as the latency of each item in a batch, into a compact summary without logging each one.
+func ObserveInt(ctx context.Context, key string, value int) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		parts := c.normalizeKey(key)
+		at := func(field string) []string {
+			return append(parts[:len(parts):len(parts)], field)
+		}
+		c.add(at("count"), 1)
+		c.add(at("sum"), value)
+		c.setIf(at("min"), value, func(old any) bool {
+			o, ok := old.(int)
+			return !ok || value < o
+		})
+		c.setIf(at("max"), value, func(old any) bool {
+			o, ok := old.(int)
+			return !ok || value > o
+		})
+	}
+}
+
+// reservoirSize is the number of observations ObserveDuration keeps per key for estimating quantiles.
+const reservoirSize = 128
+
+// reservoir is a uniform random sample of the durations observed for a key.
+type reservoir struct {
+	seen    int
+	samples []time.Duration
+}
+
+// ObserveDuration records d in a summary under key.  The summary holds the number of observations in <key>.count, their
+// total in <key>.sum_ms, the largest in <key>.max_ms and estimates of the median and 99th percentile in <key>.p50_ms
+// and <key>.p99_ms, all in fractional milliseconds.  The quantiles are exact for up to 128 observations; beyond that
+// they are computed from a uniform random sample of 128 observations, so they are approximate and may vary between
+// runs.  The sample is held in memory outside the event.
+func ObserveDuration(ctx context.Context, key string, d time.Duration) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		parts := c.normalizeKey(key)
+		at := func(field string) []string {
+			return append(parts[:len(parts):len(parts)], field)
+		}
+		ms := float64(d) / float64(time.Millisecond)
+		c.add(at("count"), 1)
+		c.addFloat(at("sum_ms"), ms)
+		c.setIf(at("max_ms"), ms, func(old any) bool {
+			o, ok := old.(float64)
+			return !ok || ms > o
+		})
+
+		if c.reservoirs == nil {
+			c.reservoirs = map[string]*reservoir{}
+		}
+		name := strings.Join(parts, ".")
+		r := c.reservoirs[name]
+		if r == nil {
+			r = &reservoir{}
+			c.reservoirs[name] = r
+		}
+		r.add(d)
+		c.put(at("p50_ms"), float64(r.quantile(0.5))/float64(time.Millisecond))
+		c.put(at("p99_ms"), float64(r.quantile(0.99))/float64(time.Millisecond))
+	}
+}
+
+// add offers d to the sample using reservoir sampling, so every observation is equally likely to be kept.
+func (r *reservoir) add(d time.Duration) {
+	r.seen++
+	if len(r.samples) < reservoirSize {
+		r.samples = append(r.samples, d)
+		return
+	}
+	if i := rand.IntN(r.seen); i < reservoirSize {
+		r.samples[i] = d
+	}
+}
+
+// quantile returns the q-quantile of the sample using the nearest-rank method.
+func (r *reservoir) quantile(q float64) time.Duration {
+	sorted := slices.Clone(r.samples)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
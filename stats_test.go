@@ -0,0 +1,56 @@
+package clog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveInt(t *testing.T) {
+	ctx := Init(context.Background())
+	for _, v := range []int{12, 5, 40, 7} {
+		ObserveInt(ctx, "batch.item_ms", v)
+	}
+
+	require.Equal(t, `{"batch":{"item_ms":{"count":4,"sum":64,"min":5,"max":40}}}`, MarshalJSON(ctx))
+}
+
+func TestObserveDuration(t *testing.T) {
+	ctx := Init(context.Background())
+	for _, ms := range []int{12, 5, 40, 7} {
+		ObserveDuration(ctx, "batch.item", time.Duration(ms)*time.Millisecond)
+	}
+	ObserveDuration(ctx, "batch.item", 500*time.Microsecond)
+
+	require.Equal(t, `{"batch":{"item":{"count":5,"sum_ms":64.5,"max_ms":40,"p50_ms":7,"p99_ms":40}}}`, MarshalJSON(ctx))
+}
+
+func TestObserveDuration_Sampled(t *testing.T) {
+	ctx := Init(context.Background())
+	for i := 1; i <= 1000; i++ {
+		ObserveDuration(ctx, "op", time.Duration(i)*time.Millisecond)
+	}
+
+	event := Snapshot(ctx)
+	count, _ := event.Int("op.count")
+	require.Equal(t, 1000, count)
+	sum, _ := event.Float64("op.sum_ms")
+	require.Equal(t, 500500.0, sum)
+	maxMs, _ := event.Float64("op.max_ms")
+	require.Equal(t, 1000.0, maxMs)
+	p50, _ := event.Float64("op.p50_ms")
+	require.InDelta(t, 500, p50, 200)
+	p99, _ := event.Float64("op.p99_ms")
+	require.InDelta(t, 990, p99, 60)
+	require.LessOrEqual(t, p50, p99)
+}
+
+func TestObserveDuration_Swap(t *testing.T) {
+	ctx := Init(context.Background())
+	ObserveDuration(ctx, "op", 100*time.Millisecond)
+	SwapAndMarshal(ctx)
+	ObserveDuration(ctx, "op", time.Millisecond)
+	require.Equal(t, `{"op":{"count":1,"sum_ms":1,"max_ms":1,"p50_ms":1,"p99_ms":1}}`, MarshalJSON(ctx))
+}
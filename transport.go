@@ -0,0 +1,79 @@
+package clog
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// CanonicalTransport is an http.RoundTripper that records the outbound HTTP calls made during a unit of work in the
+// canonical logging context.
+type CanonicalTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// NewCanonicalTransport returns a RoundTripper that tallies each request it carries in the canonical logging context
+// of ctx.  The number of calls per host is recorded in http.client.<host>.count and the total time spent in
+// http.client.<host>.duration_ms.  If base is nil, http.DefaultTransport is used.
+func NewCanonicalTransport(ctx context.Context, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &CanonicalTransport{ctx: ctx, base: base}
+}
+
+func (ct *CanonicalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := ct.base.RoundTrip(req)
+	duration := time.Since(start)
+
+	host := req.URL.Host
+	AddIntPath(ct.ctx, 1, "http", "client", host, "count")
+	AddIntPath(ct.ctx, int(duration.Milliseconds()), "http", "client", host, "duration_ms")
+	return resp, err
+}
+
+// WithClientTrace returns a context carrying an httptrace.ClientTrace that records the phases of outbound requests in
+// the canonical logging context of ctx: http.client.timing.dns_ms, connect_ms, tls_ms and ttfb_ms, the time from
+// requesting a connection to the first response byte.  Phases that do not occur, such as DNS for a reused connection,
+// are not recorded.  Later requests made with the returned context overwrite the timings of earlier ones.
+//
+//	ctx, _ = clog.WithClientTrace(ctx)
+//	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+func WithClientTrace(ctx context.Context) (context.Context, *httptrace.ClientTrace) {
+	now := time.Now
+	if c, ok := fromContext(ctx); ok {
+		now = c.opts.now
+	}
+
+	var mu sync.Mutex
+	var start, dnsStart, connectStart, tlsStart time.Time
+	mark := func(t *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		*t = now()
+	}
+	record := func(key string, since *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !since.IsZero() {
+			SetInt(ctx, "http.client.timing."+key, int(now().Sub(*since).Milliseconds()))
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		GetConn:              func(string) { mark(&start) },
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { record("dns_ms", &dnsStart) },
+		ConnectStart:         func(string, string) { mark(&connectStart) },
+		ConnectDone:          func(string, string, error) { record("connect_ms", &connectStart) },
+		TLSHandshakeStart:    func() { mark(&tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { record("tls_ms", &tlsStart) },
+		GotFirstResponseByte: func() { record("ttfb_ms", &start) },
+	}
+	return httptrace.WithClientTrace(ctx, trace), trace
+}
@@ -0,0 +1,58 @@
+package clog
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCanonicalTransport(t *testing.T) {
+	ctx := Init(context.Background())
+	stub := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	client := &http.Client{Transport: NewCanonicalTransport(ctx, stub)}
+
+	for _, url := range []string{"http://api.example.com/a", "http://api.example.com/b", "http://auth.example.com:8080/token"} {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.Equal(t, `{"http":{"client":{"api.example.com":{"count":2,"duration_ms":0},"auth.example.com:8080":{"count":1,"duration_ms":0}}}}`, MarshalJSON(ctx))
+}
+
+func TestWithClientTrace(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	advance := func(ms int) { now = now.Add(time.Duration(ms) * time.Millisecond) }
+	ctx := InitWithOptions(context.Background(), WithClock(func() time.Time { return now }))
+
+	traced, trace := WithClientTrace(ctx)
+	require.Same(t, trace, httptrace.ContextClientTrace(traced))
+
+	trace.GetConn("api.example.com:443")
+	trace.DNSStart(httptrace.DNSStartInfo{Host: "api.example.com"})
+	advance(5)
+	trace.DNSDone(httptrace.DNSDoneInfo{})
+	trace.ConnectStart("tcp", "10.0.0.1:443")
+	advance(10)
+	trace.ConnectDone("tcp", "10.0.0.1:443", nil)
+	trace.TLSHandshakeStart()
+	advance(20)
+	trace.TLSHandshakeDone(tls.ConnectionState{}, nil)
+	advance(15)
+	trace.GotFirstResponseByte()
+
+	require.Equal(t, `{"http":{"client":{"timing":{"dns_ms":5,"connect_ms":10,"tls_ms":20,"ttfb_ms":50}}}}`, MarshalJSON(ctx))
+}
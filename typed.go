@@ -0,0 +1,76 @@
+package clog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Kind is the JSON type a value is parsed into by SetTyped.
+type Kind int
+
+// Kinds supported by SetTyped.
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// SetTyped parses value as kind and sets the result in the canonical logging context.  This is useful when values
+// arrive as strings, such as from headers or query parameters, but should be logged with their proper type.  If value
+// cannot be parsed, nothing is stored under key and the error is recorded in clog.error.
+func SetTyped(ctx context.Context, key, value string, kind Kind) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		var v any
+		var err error
+		switch kind {
+		case KindString:
+			v = value
+		case KindInt:
+			v, err = strconv.Atoi(value)
+		case KindFloat:
+			v, err = strconv.ParseFloat(value, 64)
+		case KindBool:
+			v, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("unknown kind %s", kind)
+		}
+		if err != nil {
+			c.recordError(fmt.Errorf("SetTyped %s: %w", key, err))
+			return
+		}
+		c.setValue(key, v)
+	}
+}
+
+// SetEnum sets a categorical string value in the canonical logging context.  If value is not one of allowed, it is
+// still stored, but key is recorded in error.invalid_enum so typos in categorical fields can be found.
+func SetEnum(ctx context.Context, key, value string, allowed ...string) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setString(key, value)
+		if !slices.Contains(allowed, value) {
+			c.setString("error.invalid_enum", key)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package clog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTyped(t *testing.T) {
+	ctx := Init(context.Background())
+	SetTyped(ctx, "user.zip", "02134", KindString)
+	SetTyped(ctx, "user.age", "42", KindInt)
+	SetTyped(ctx, "user.score", "98.5", KindFloat)
+	SetTyped(ctx, "user.admin", "true", KindBool)
+
+	require.Equal(t, `{"user":{"zip":"02134","age":42,"score":98.5,"admin":true}}`, MarshalJSON(ctx))
+}
+
+func TestSetTyped_ParseErrors(t *testing.T) {
+	tests := []struct {
+		kind  Kind
+		value string
+		err   string
+	}{
+		{KindInt, "4.2", `SetTyped user.value: strconv.Atoi: parsing \"4.2\": invalid syntax`},
+		{KindFloat, "abc", `SetTyped user.value: strconv.ParseFloat: parsing \"abc\": invalid syntax`},
+		{KindBool, "yes", `SetTyped user.value: strconv.ParseBool: parsing \"yes\": invalid syntax`},
+		{Kind(99), "x", `SetTyped user.value: unknown kind Kind(99)`},
+	}
+	for _, tt := range tests {
+		ctx := Init(context.Background())
+		SetTyped(ctx, "user.value", tt.value, tt.kind)
+		require.Equal(t, `{"clog":{"error":"`+tt.err+`"}}`, MarshalJSON(ctx))
+	}
+}
+
+func TestSetEnum(t *testing.T) {
+	ctx := Init(context.Background())
+	SetEnum(ctx, "job.result", "success", "success", "failure", "skipped")
+	require.Equal(t, `{"job":{"result":"success"}}`, MarshalJSON(ctx))
+
+	ctx = Init(context.Background())
+	SetEnum(ctx, "job.result", "sucess", "success", "failure", "skipped")
+	require.Equal(t, `{"job":{"result":"sucess"},"error":{"invalid_enum":"job.result"}}`, MarshalJSON(ctx))
+}
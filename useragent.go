@@ -0,0 +1,100 @@
+package clog
+
+import "strings"
+
+// UserAgent holds the structured fields parsed from a User-Agent header.
+type UserAgent struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+// UserAgentParser parses a raw User-Agent header into structured fields.
+type UserAgentParser func(ua string) UserAgent
+
+// WithUserAgentParsing configures the middleware to record the User-Agent header under http.request.user_agent.  The
+// raw header is stored in http.request.user_agent.original and the parsed fields in .browser, .os and .device.  If
+// parser is nil, ParseUserAgent is used.  Pass a parser backed by a full UA database for more accurate results.
+func WithUserAgentParsing(parser UserAgentParser) Option {
+	if parser == nil {
+		parser = ParseUserAgent
+	}
+	return func(o *options) {
+		o.parseUserAgent = parser
+	}
+}
+
+// ParseUserAgent is a small User-Agent parser that recognizes common browsers, operating systems and device types.
+// Unrecognized values are reported as "other".
+func ParseUserAgent(ua string) UserAgent {
+	lower := strings.ToLower(ua)
+	return UserAgent{
+		Browser: parseBrowser(lower),
+		OS:      parseOS(lower),
+		Device:  parseDevice(lower),
+	}
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case containsAny(ua, "bot", "spider", "crawler"):
+		return "bot"
+	case strings.Contains(ua, "edg/"):
+		return "edge"
+	case containsAny(ua, "opr/", "opera"):
+		return "opera"
+	case containsAny(ua, "firefox/", "fxios/"):
+		return "firefox"
+	case containsAny(ua, "chrome/", "crios/"):
+		return "chrome"
+	case strings.Contains(ua, "safari/"):
+		return "safari"
+	case strings.HasPrefix(ua, "curl/"):
+		return "curl"
+	}
+	return "other"
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case containsAny(ua, "iphone", "ipad", "ipod"):
+		return "ios"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case containsAny(ua, "mac os x", "macintosh"):
+		return "macos"
+	case strings.Contains(ua, "cros "):
+		// The trailing space keeps the CrOS token from matching words such as "microsoft".
+		return "chromeos"
+	case strings.Contains(ua, "linux"):
+		return "linux"
+	}
+	return "other"
+}
+
+func parseDevice(ua string) string {
+	switch {
+	case containsAny(ua, "bot", "spider", "crawler"):
+		return "bot"
+	case containsAny(ua, "ipad", "tablet"):
+		return "tablet"
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return "tablet"
+	case containsAny(ua, "mobi", "iphone", "ipod"):
+		return "mobile"
+	case containsAny(ua, "windows", "macintosh", "linux", "cros "):
+		return "desktop"
+	}
+	return "other"
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
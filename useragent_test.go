@@ -0,0 +1,50 @@
+package clog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want UserAgent
+	}{
+		{
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want: UserAgent{Browser: "chrome", OS: "windows", Device: "desktop"},
+		},
+		{
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			want: UserAgent{Browser: "safari", OS: "ios", Device: "mobile"},
+		},
+		{
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want: UserAgent{Browser: "firefox", OS: "macos", Device: "desktop"},
+		},
+		{
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: UserAgent{Browser: "bot", OS: "other", Device: "bot"},
+		},
+		{
+			ua:   "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want: UserAgent{Browser: "chrome", OS: "chromeos", Device: "desktop"},
+		},
+		{
+			ua:   "Microsoft-CryptoAPI/10.0",
+			want: UserAgent{Browser: "other", OS: "other", Device: "other"},
+		},
+		{
+			ua:   "Microsoft Office/16.0 (Macintosh; Mac OS X 14.1; Microsoft Word 16.78)",
+			want: UserAgent{Browser: "other", OS: "macos", Device: "desktop"},
+		},
+		{
+			ua:   "curl/8.4.0",
+			want: UserAgent{Browser: "curl", OS: "other", Device: "other"},
+		},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, ParseUserAgent(tt.ua), tt.ua)
+	}
+}
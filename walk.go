@@ -0,0 +1,128 @@
+package clog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wk8/go-ordered-map/v2"
+)
+
+// Walk performs a depth-first traversal of the canonical logging context, calling fn for each leaf value in insertion
+// order.  Namespaces are visited under their names, as they appear in the marshaled event.  path holds the key
+// segments leading to the value.  fn must not retain path.  fn is called while the context is locked, so it must not
+// call other clog functions on the same context.
+func Walk(ctx context.Context, fn func(path []string, value any)) {
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		values := c.values
+		if len(c.namespaces) > 0 {
+			values, _ = c.merged()
+		}
+		walk(values, nil, fn)
+	}
+}
+
+// NumericFields returns the numeric values of the event, keyed by their dot-separated path, for pushing to metrics
+// systems such as StatsD or Prometheus.  Strings, bools, arrays and other values are left out.  Keys are those of the
+// marshaled event, so aliased keys use their new names and redacted values are left out.
+func NumericFields(ctx context.Context) map[string]float64 {
+	fields := map[string]float64{}
+	if c, ok := fromContext(ctx); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		walk(c.render(), nil, func(path []string, value any) {
+			if f, ok := toFloat64(value); ok {
+				fields[strings.Join(path, ".")] = f
+			}
+		})
+	}
+	return fields
+}
+
+// toFloat64 converts numeric values to float64.
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func walk(m *orderedmap.OrderedMap[string, any], path []string, fn func(path []string, value any)) { //nolint:typecheck
+	for pair := m.Oldest(); pair != nil; pair = pair.Next() {
+		path := append(path, pair.Key)
+		if child, ok := pair.Value.(*orderedmap.OrderedMap[string, any]); ok {
+			walk(child, path, fn)
+			continue
+		}
+		fn(path, pair.Value)
+	}
+}
+
+// toPlain converts ordered maps in v, including those nested in slices, into plain maps for encoders that do not
+// understand ordered maps.
+func toPlain(v any) any {
+	switch vv := v.(type) {
+	case *orderedmap.OrderedMap[string, any]: //nolint:typecheck
+		m := make(map[string]any, vv.Len())
+		for pair := vv.Oldest(); pair != nil; pair = pair.Next() {
+			m[pair.Key] = toPlain(pair.Value)
+		}
+		return m
+	case []any:
+		s := make([]any, len(vv))
+		for i, item := range vv {
+			s[i] = toPlain(item)
+		}
+		return s
+	}
+	return v
+}
+
+// cloneMap returns a deep copy of m.  Nested ordered maps and slices are copied; other values are shared.
+func cloneMap(m *orderedmap.OrderedMap[string, any]) *orderedmap.OrderedMap[string, any] { //nolint:typecheck
+	out := orderedmap.New[string, any](orderedmap.WithCapacity[string, any](m.Len())) //nolint:typecheck
+	for pair := m.Oldest(); pair != nil; pair = pair.Next() {
+		out.Set(pair.Key, cloneValue(pair.Value))
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch vv := v.(type) {
+	case *orderedmap.OrderedMap[string, any]: //nolint:typecheck
+		return cloneMap(vv)
+	case []any:
+		s := make([]any, len(vv))
+		for i, item := range vv {
+			s[i] = cloneValue(item)
+		}
+		return s
+	case []string:
+		return append([]string(nil), vv...)
+	}
+	return v
+}
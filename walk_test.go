@@ -0,0 +1,53 @@
+package clog
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	ctx := Init(context.Background())
+	SetString(ctx, "request_id", "req-123")
+	SetString(ctx, "http.request.method", "GET")
+	SetInt(ctx, "http.response.status_code", 200)
+	AddIntPath(ctx, 1, "db", "tables", "public.users")
+
+	var paths []string
+	var values []any
+	Walk(ctx, func(path []string, value any) {
+		paths = append(paths, strings.Join(path, "/"))
+		values = append(values, value)
+	})
+
+	require.Equal(t, []string{"request_id", "http/request/method", "http/response/status_code", "db/tables/public.users"}, paths)
+	require.Equal(t, []any{"req-123", "GET", 200, 1}, values)
+}
+
+func TestWalk_Uninitialized(t *testing.T) {
+	Walk(context.Background(), func(path []string, value any) {
+		t.Fatal("unexpected value")
+	})
+}
+
+func TestNumericFields(t *testing.T) {
+	ctx := InitWithOptions(context.Background(), WithRedactKeys("user.age"))
+	SetString(ctx, "http.request.method", "GET")
+	SetInt(ctx, "http.response.status_code", 200)
+	SetFloat64(ctx, "http.response.duration_ms", 12.5)
+	SetDurationNanos(ctx, "db.duration", 1500)
+	TallyBool(ctx, "cache.hit", true)
+	OrBool(ctx, "cache.any_miss", false)
+	SetInt(ctx, "user.age", 42)
+	AppendObject(ctx, "items", "id", 1)
+
+	require.Equal(t, map[string]float64{
+		"http.response.status_code": 200,
+		"http.response.duration_ms": 12.5,
+		"db.duration":               1500,
+		"cache.hit.true":            1,
+	}, NumericFields(ctx))
+	require.Empty(t, NumericFields(context.Background()))
+}